@@ -0,0 +1,114 @@
+package bandwidth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// minBurst lets a whole transfer block through at once even under very low limits.
+	minBurst = 64 * 1024
+	// peerIdleTimeout is how long an unused per-peer bucket is kept around.
+	peerIdleTimeout = 10 * time.Minute
+)
+
+// Limiter throttles a byte stream with token buckets: one shared by all traffic and
+// one per remote peer. A limit of 0 means unlimited. Limits can be changed while
+// transfers are running.
+type Limiter struct {
+	mu          sync.Mutex
+	global      *rate.Limiter
+	globalRate  int64
+	perPeerRate int64
+	peers       map[string]*peerBucket
+	lastSweep   time.Time
+}
+
+type peerBucket struct {
+	limiter  *rate.Limiter
+	lastUsed time.Time
+}
+
+// NewLimiter creates a limiter with the given global and per-peer rates in bytes per second.
+func NewLimiter(globalRate, perPeerRate int64) *Limiter {
+	l := &Limiter{
+		global: rate.NewLimiter(rate.Inf, minBurst),
+		peers:  make(map[string]*peerBucket),
+	}
+	l.SetLimits(globalRate, perPeerRate)
+	return l
+}
+
+// SetLimits changes the global and per-peer rates in bytes per second.
+func (l *Limiter) SetLimits(globalRate, perPeerRate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.globalRate = max(globalRate, 0)
+	l.perPeerRate = max(perPeerRate, 0)
+	configure(l.global, l.globalRate)
+	for _, b := range l.peers {
+		configure(b.limiter, l.perPeerRate)
+	}
+}
+
+// Limits returns the current global and per-peer rates in bytes per second.
+func (l *Limiter) Limits() (globalRate, perPeerRate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.globalRate, l.perPeerRate
+}
+
+// WaitN blocks until n bytes may be transferred to or from peer, or ctx is done.
+func (l *Limiter) WaitN(ctx context.Context, peer string, n int) error {
+	peerLimiter := l.peerLimiter(peer)
+	// A rate.Limiter can't grant more than its burst at once, so wait in burst-sized steps.
+	for n > 0 {
+		step := min(n, minBurst)
+		if err := peerLimiter.WaitN(ctx, step); err != nil {
+			return err
+		}
+		if err := l.global.WaitN(ctx, step); err != nil {
+			return err
+		}
+		n -= step
+	}
+	return nil
+}
+
+func (l *Limiter) peerLimiter(peer string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > peerIdleTimeout {
+		for id, b := range l.peers {
+			if now.Sub(b.lastUsed) > peerIdleTimeout {
+				delete(l.peers, id)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.peers[peer]
+	if !ok {
+		b = &peerBucket{limiter: rate.NewLimiter(rate.Inf, minBurst)}
+		configure(b.limiter, l.perPeerRate)
+		l.peers[peer] = b
+	}
+	b.lastUsed = now
+	return b.limiter
+}
+
+// configure sets a token bucket to bytesPerSecond, with a burst of one second's worth
+// of traffic but never less than minBurst.
+func configure(limiter *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	limiter.SetBurst(int(max(bytesPerSecond, minBurst)))
+	limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
@@ -0,0 +1,86 @@
+package bandwidth
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ParseRate parses a rate in bytes per second such as "512K", "10MB", "1.5MiB/s" or "2G".
+// Suffixes are binary multiples (K = 1024). An empty string or "0" means unlimited.
+func ParseRate(s string) (int64, error) {
+	value := strings.TrimSuffix(strings.TrimSpace(strings.ToUpper(s)), "/S")
+	n, err := ParseSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q (expected e.g. 512K, 10M or 0 for unlimited)", s)
+	}
+	return n, nil
+}
+
+// ParseSize parses a number of bytes such as "512K", "10MB", "1.5GiB" or "2T".
+// Suffixes are binary multiples (K = 1024). An empty string means 0.
+func ParseSize(s string) (int64, error) {
+	value := strings.TrimSpace(strings.ToUpper(s))
+	if value == "" {
+		return 0, nil
+	}
+	value = strings.TrimSuffix(value, "B")
+	value = strings.TrimSuffix(value, "I")
+
+	multiplier := int64(1)
+	switch {
+	case strings.HasSuffix(value, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(value, "G"):
+		multiplier = 1 << 30
+	case strings.HasSuffix(value, "T"):
+		multiplier = 1 << 40
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(number) || math.IsInf(number, 0) || number < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 512M or 10G)", s)
+	}
+	// float64(math.MaxInt64) rounds up to 2^63, which is already out of range.
+	bytes := number * float64(multiplier)
+	if bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("size %q is too large", s)
+	}
+	return int64(bytes), nil
+}
+
+// FormatRate renders a rate in bytes per second for display.
+func FormatRate(bytesPerSecond int64) string {
+	switch {
+	case bytesPerSecond <= 0:
+		return "unlimited"
+	case bytesPerSecond >= 1<<30:
+		return fmt.Sprintf("%.1f GiB/s", float64(bytesPerSecond)/(1<<30))
+	case bytesPerSecond >= 1<<20:
+		return fmt.Sprintf("%.1f MiB/s", float64(bytesPerSecond)/(1<<20))
+	case bytesPerSecond >= 1<<10:
+		return fmt.Sprintf("%.1f KiB/s", float64(bytesPerSecond)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B/s", bytesPerSecond)
+	}
+}
+
+// FormatSize renders a number of bytes for display.
+func FormatSize(bytes int64) string {
+	switch {
+	case bytes >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(bytes)/(1<<30))
+	case bytes >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(bytes)/(1<<20))
+	case bytes >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(bytes)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", bytes)
+	}
+}
@@ -0,0 +1,188 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	peerValidity = 2 * 365 * 24 * time.Hour
+)
+
+// GenerateCA creates a self-signed CA for a test network.
+func GenerateCA(commonName string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: commonName, Organization: []string{"PeerNet"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	return cert, key, err
+}
+
+// IssuePeerCert issues a certificate that ties a new key to a tracker peer ID. The peer
+// ID is the certificate's common name and its first DNS name, which is what other peers
+// verify against. Extra hosts (names or IPs) are added as further SANs. The certificate
+// is valid for both serving and client authentication, as needed for mutual TLS.
+func IssuePeerCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, peerID string, hosts []string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := signPeerCert(ca, caKey, peerID, &key.PublicKey, hosts)
+	return cert, key, err
+}
+
+// NewPeerCSR creates a key and a certificate signing request for it, naming the peer ID
+// and hosts the certificate should be issued for. Only the request leaves the peer; the
+// CA's operator signs it with SignPeerCSR.
+func NewPeerCSR(peerID string, hosts []string) (*x509.CertificateRequest, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	template := &x509.CertificateRequest{Subject: pkix.Name{CommonName: peerID, Organization: []string{"PeerNet"}}}
+	addHosts(&template.DNSNames, &template.IPAddresses, hosts)
+	der, err := x509.CreateCertificateRequest(rand.Reader, template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	return csr, key, err
+}
+
+// SignPeerCSR issues a peer certificate for the key, peer ID and hosts in a certificate
+// signing request, plus any extra hosts. The caller must have checked that the request
+// really comes from the peer it names.
+func SignPeerCSR(ca *x509.Certificate, caKey *ecdsa.PrivateKey, csr *x509.CertificateRequest, hosts []string) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid certificate request: %v", err)
+	}
+	peerID := csr.Subject.CommonName
+	if peerID == "" {
+		return nil, fmt.Errorf("certificate request names no peer ID")
+	}
+	for _, name := range csr.DNSNames {
+		if name != peerID {
+			hosts = append(hosts, name)
+		}
+	}
+	for _, ip := range csr.IPAddresses {
+		hosts = append(hosts, ip.String())
+	}
+	return signPeerCert(ca, caKey, peerID, csr.PublicKey, hosts)
+}
+
+func signPeerCert(ca *x509.Certificate, caKey *ecdsa.PrivateKey, peerID string, pub any, hosts []string) (*x509.Certificate, error) {
+	template := &x509.Certificate{
+		SerialNumber: newSerial(),
+		Subject:      pkix.Name{CommonName: peerID, Organization: []string{"PeerNet"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(peerValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{peerID},
+	}
+	addHosts(&template.DNSNames, &template.IPAddresses, hosts)
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, pub, caKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// addHosts adds host names and IPs as subject alternative names.
+func addHosts(dnsNames *[]string, ips *[]net.IP, hosts []string) {
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			*ips = append(*ips, ip)
+		} else if host != "" {
+			*dnsNames = append(*dnsNames, host)
+		}
+	}
+}
+
+// WriteCert writes a certificate as PEM.
+func WriteCert(path string, cert *x509.Certificate) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644)
+}
+
+// WriteKey writes a private key as PEM, readable only by the owner.
+func WriteKey(path string, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// WriteCSR writes a certificate signing request as PEM.
+func WriteCSR(path string, csr *x509.CertificateRequest) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr.Raw}), 0644)
+}
+
+// ReadCSR reads a PEM certificate signing request.
+func ReadCSR(path string) (*x509.CertificateRequest, error) {
+	block, err := readPEM(path, "CERTIFICATE REQUEST")
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificateRequest(block.Bytes)
+}
+
+// ReadCert reads a PEM certificate.
+func ReadCert(path string) (*x509.Certificate, error) {
+	block, err := readPEM(path, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// ReadKey reads a PEM EC private key.
+func ReadKey(path string) (*ecdsa.PrivateKey, error) {
+	block, err := readPEM(path, "EC PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseECPrivateKey(block.Bytes)
+}
+
+func readPEM(path, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("%s does not contain a PEM %s", path, blockType)
+	}
+	return block, nil
+}
+
+func newSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
@@ -0,0 +1,136 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"google.golang.org/grpc/credentials"
+)
+
+// ServerCredentials builds the gRPC transport credentials for serving chunks.
+// It returns nil if TLS is not configured. With Mutual set, clients must present a
+// certificate signed by the configured CA.
+func ServerCredentials(cfg config.PeerTLS) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, fmt.Errorf("serving over TLS requires peer_tls.cert_file and peer_tls.key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load peer certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.Mutual {
+		pool, err := loadCAPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// ClientCredentials builds the gRPC transport credentials for downloading chunks.
+// It returns nil if TLS is not configured. The server name is left empty so that each
+// connection verifies the remote certificate against the peer ID it was dialed with.
+func ClientCredentials(cfg config.PeerTLS) (credentials.TransportCredentials, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	pool, err := loadCAPool(cfg.CAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load peer certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	} else if cfg.Mutual {
+		return nil, fmt.Errorf("mutual TLS requires peer_tls.cert_file and peer_tls.key_file")
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// TrackerTLSConfig builds the TLS configuration used to talk to an HTTPS tracker.
+// It returns nil if nothing is configured, leaving the default verification in place.
+// A pinned fingerprint alone is enough to trust a self-signed tracker; with a CA file
+// as well, the certificate must satisfy both.
+func TrackerTLSConfig(cfg config.TrackerTLS) (*tls.Config, error) {
+	if cfg == (config.TrackerTLS{}) {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.CAFile != "" {
+		pool, err := loadCAPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" && cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load tracker client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CertSHA256 != "" {
+		pin, err := hex.DecodeString(strings.ReplaceAll(cfg.CertSHA256, ":", ""))
+		if err != nil || len(pin) != sha256.Size {
+			return nil, fmt.Errorf("tracker_tls.cert_sha256 must be a hex SHA-256 fingerprint")
+		}
+		if cfg.CAFile == "" {
+			// The pin replaces chain verification, which a self-signed certificate can't pass.
+			tlsConfig.InsecureSkipVerify = true
+		}
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("tracker presented no certificate")
+			}
+			fingerprint := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if !strings.EqualFold(hex.EncodeToString(fingerprint[:]), hex.EncodeToString(pin)) {
+				return fmt.Errorf("tracker certificate fingerprint %x does not match the pinned one", fingerprint)
+			}
+			return nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// loadCAPool reads the CA bundle peers are verified against. Without one, the
+// system roots are used.
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	if caFile == "" {
+		return x509.SystemCertPool()
+	}
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ShreyamKundu/peernet/peer/certs"
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/spf13/cobra"
+)
+
+var certsCmd = &cobra.Command{
+	Use:   "certs",
+	Short: "Manage TLS certificates for peer-to-peer transfers",
+}
+
+var certsInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate a CA and a certificate for this peer",
+	Long: `Creates a CA in the certs directory (or reuses the one there), issues this peer a
+certificate tied to its tracker peer ID, and enables TLS for chunk transfers in the
+config file. Intended for test networks.
+
+Run it on one peer only. Its CA key can issue a certificate for any peer ID, so it
+must never be copied to other peers; they send a certificate request instead and
+install the certificate this peer signs:
+
+  peernet certs init --mutual                  # on the CA host
+  peernet certs request                        # on every other peer
+  peernet certs sign peer.csr                  # on the CA host, after checking the peer ID
+  peernet certs install <peer-id>.pem --ca-cert ca.pem --mutual   # on the other peer`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, peerID := loadPeerIdentity()
+		dir := certsDir(cmd)
+
+		caCertPath, caKeyPath := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")
+		if _, err := os.Stat(caCertPath); os.IsNotExist(err) {
+			ca, caKey, err := certs.GenerateCA("PeerNet Test CA")
+			if err != nil {
+				log.Fatalf("Failed to generate CA: %v", err)
+			}
+			if err := certs.WriteCert(caCertPath, ca); err != nil {
+				log.Fatalf("Failed to write CA certificate: %v", err)
+			}
+			if err := certs.WriteKey(caKeyPath, caKey); err != nil {
+				log.Fatalf("Failed to write CA key: %v", err)
+			}
+			fmt.Printf("Created CA in %s\n", caCertPath)
+		}
+		ca, caKey := readCA(dir)
+
+		hosts, _ := cmd.Flags().GetStringSlice("host")
+		cert, key, err := certs.IssuePeerCert(ca, caKey, peerID, hosts)
+		if err != nil {
+			log.Fatalf("Failed to issue peer certificate: %v", err)
+		}
+		certPath, keyPath := filepath.Join(dir, "peer.pem"), filepath.Join(dir, "peer-key.pem")
+		if err := certs.WriteCert(certPath, cert); err != nil {
+			log.Fatalf("Failed to write peer certificate: %v", err)
+		}
+		if err := certs.WriteKey(keyPath, key); err != nil {
+			log.Fatalf("Failed to write peer key: %v", err)
+		}
+
+		mutual, _ := cmd.Flags().GetBool("mutual")
+		enablePeerTLS(cfg, dir, mutual)
+		fmt.Printf("✅ Issued certificate for peer %s in %s (mutual TLS: %t). Restart 'peernet serve' to use it.\n", peerID, certPath, mutual)
+	},
+}
+
+var certsRequestCmd = &cobra.Command{
+	Use:   "request",
+	Short: "Create a key and a certificate request for this peer",
+	Long: `Generates this peer's TLS key in the certs directory and a certificate signing
+request for its tracker peer ID. Send the request to the peer holding the network's CA,
+which signs it with 'peernet certs sign', then install the certificate it returns with
+'peernet certs install'. The key never leaves this peer.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		_, peerID := loadPeerIdentity()
+		dir := certsDir(cmd)
+
+		hosts, _ := cmd.Flags().GetStringSlice("host")
+		csr, key, err := certs.NewPeerCSR(peerID, hosts)
+		if err != nil {
+			log.Fatalf("Failed to create certificate request: %v", err)
+		}
+		if err := certs.WriteKey(filepath.Join(dir, "peer-key.pem"), key); err != nil {
+			log.Fatalf("Failed to write peer key: %v", err)
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if err := certs.WriteCSR(out, csr); err != nil {
+			log.Fatalf("Failed to write certificate request: %v", err)
+		}
+		fmt.Printf("✅ Wrote certificate request for peer %s to %s. Have the CA host run 'peernet certs sign %s'.\n", peerID, out, filepath.Base(out))
+	},
+}
+
+var certsSignCmd = &cobra.Command{
+	Use:   "sign <request-file>",
+	Short: "Sign another peer's certificate request with this peer's CA",
+	Long: `Issues a certificate for the peer ID named in a request made with 'peernet certs
+request', using the CA created by 'peernet certs init'. Anyone holding a certificate for
+a peer ID can act as that peer on TLS connections, so only sign requests whose peer ID
+you have confirmed with the peer that sent them.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := certsDir(cmd)
+		ca, caKey := readCA(dir)
+		csr, err := certs.ReadCSR(args[0])
+		if err != nil {
+			log.Fatalf("Failed to read certificate request: %v", err)
+		}
+
+		hosts, _ := cmd.Flags().GetStringSlice("host")
+		cert, err := certs.SignPeerCSR(ca, caKey, csr, hosts)
+		if err != nil {
+			log.Fatalf("Failed to sign certificate request: %v", err)
+		}
+		out, _ := cmd.Flags().GetString("out")
+		if out == "" {
+			out = cert.Subject.CommonName + ".pem"
+		}
+		if err := certs.WriteCert(out, cert); err != nil {
+			log.Fatalf("Failed to write certificate: %v", err)
+		}
+		fmt.Printf("✅ Issued certificate for peer %s in %s. Send it back with %s.\n",
+			cert.Subject.CommonName, out, filepath.Join(dir, "ca.pem"))
+	},
+}
+
+var certsInstallCmd = &cobra.Command{
+	Use:   "install <certificate-file>",
+	Short: "Install a certificate signed for this peer and enable TLS",
+	Long: `Installs the certificate the CA host issued with 'peernet certs sign' for the
+request this peer made, along with the network's CA certificate, and enables TLS for
+chunk transfers in the config file.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, peerID := loadPeerIdentity()
+		dir := certsDir(cmd)
+
+		caCertPath, _ := cmd.Flags().GetString("ca-cert")
+		if caCertPath == "" {
+			log.Fatal("--ca-cert is required")
+		}
+		ca, err := certs.ReadCert(caCertPath)
+		if err != nil {
+			log.Fatalf("Failed to read CA certificate: %v", err)
+		}
+		cert, err := certs.ReadCert(args[0])
+		if err != nil {
+			log.Fatalf("Failed to read certificate: %v", err)
+		}
+		key, err := certs.ReadKey(filepath.Join(dir, "peer-key.pem"))
+		if err != nil {
+			log.Fatalf("Failed to read this peer's key (run 'peernet certs request' first): %v", err)
+		}
+		if cert.Subject.CommonName != peerID {
+			log.Fatalf("Certificate is for peer %s, not this peer (%s).", cert.Subject.CommonName, peerID)
+		}
+		if !key.PublicKey.Equal(cert.PublicKey) {
+			log.Fatal("Certificate is not for this peer's key; was it signed from another request?")
+		}
+		if err := cert.CheckSignatureFrom(ca); err != nil {
+			log.Fatalf("Certificate was not issued by the given CA: %v", err)
+		}
+
+		if err := certs.WriteCert(filepath.Join(dir, "peer.pem"), cert); err != nil {
+			log.Fatalf("Failed to write peer certificate: %v", err)
+		}
+		if err := certs.WriteCert(filepath.Join(dir, "ca.pem"), ca); err != nil {
+			log.Fatalf("Failed to copy CA certificate: %v", err)
+		}
+		mutual, _ := cmd.Flags().GetBool("mutual")
+		enablePeerTLS(cfg, dir, mutual)
+		fmt.Printf("✅ Installed certificate for peer %s (mutual TLS: %t). Restart 'peernet serve' to use it.\n", peerID, mutual)
+	},
+}
+
+// loadPeerIdentity loads the configuration and this peer's tracker peer ID.
+func loadPeerIdentity() (*config.Config, string) {
+	cfg, err := config.Load()
+	if err != nil || cfg.AuthToken == "" {
+		log.Fatal("Configuration not found. Please run 'peernet register' first.")
+	}
+	if cfg.PeerID == "" {
+		// Configs written before the peer ID was saved still carry it in the token.
+		if cfg.PeerID = peerIDFromToken(cfg.AuthToken); cfg.PeerID == "" {
+			log.Fatal("Could not determine this peer's ID. Please run 'peernet register' again.")
+		}
+	}
+	return cfg, cfg.PeerID
+}
+
+// certsDir returns the directory given with --dir, or ~/.peernet/certs, creating it.
+func certsDir(cmd *cobra.Command) string {
+	dir, _ := cmd.Flags().GetString("dir")
+	if dir == "" {
+		configDir, err := config.Dir()
+		if err != nil {
+			log.Fatalf("Failed to locate configuration directory: %v", err)
+		}
+		dir = filepath.Join(configDir, "certs")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		log.Fatalf("Failed to create certs directory: %v", err)
+	}
+	return dir
+}
+
+// readCA reads the CA created by 'peernet certs init' in dir.
+func readCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	ca, err := certs.ReadCert(filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		log.Fatalf("Failed to read CA certificate (run 'peernet certs init' on the CA host): %v", err)
+	}
+	caKey, err := certs.ReadKey(filepath.Join(dir, "ca-key.pem"))
+	if err != nil {
+		log.Fatalf("Failed to read CA key (only the CA host can sign): %v", err)
+	}
+	return ca, caKey
+}
+
+// enablePeerTLS points the configuration at the certificates in dir and saves it.
+func enablePeerTLS(cfg *config.Config, dir string, mutual bool) {
+	cfg.PeerTLS = config.PeerTLS{
+		CertFile: filepath.Join(dir, "peer.pem"),
+		KeyFile:  filepath.Join(dir, "peer-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		Mutual:   mutual,
+	}
+	if err := cfg.Save(); err != nil {
+		log.Fatalf("Failed to save configuration: %v", err)
+	}
+}
+
+// peerIDFromToken reads the subject of a JWT without verifying it.
+func peerIDFromToken(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+	var claims struct {
+		Subject string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil {
+		return ""
+	}
+	return claims.Subject
+}
+
+func init() {
+	for _, cmd := range []*cobra.Command{certsInitCmd, certsRequestCmd, certsSignCmd, certsInstallCmd} {
+		cmd.Flags().String("dir", "", "Certificates directory (default ~/.peernet/certs)")
+		certsCmd.AddCommand(cmd)
+	}
+	for _, cmd := range []*cobra.Command{certsInitCmd, certsRequestCmd, certsSignCmd} {
+		cmd.Flags().StringSlice("host", nil, "Additional host names or IPs to include in the certificate")
+	}
+	for _, cmd := range []*cobra.Command{certsInitCmd, certsInstallCmd} {
+		cmd.Flags().Bool("mutual", false, "Require certificates from every peer (mutual TLS)")
+	}
+	certsRequestCmd.Flags().String("out", "peer.csr", "File to write the certificate request to")
+	certsSignCmd.Flags().String("out", "", "File to write the certificate to (default <peer-id>.pem)")
+	certsInstallCmd.Flags().String("ca-cert", "", "The network's CA certificate, from the CA host")
+	rootCmd.AddCommand(certsCmd)
+}
@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/ShreyamKundu/peernet/peer/daemon"
+)
+
+// daemonTokenPath returns where the running daemon keeps its control token.
+func daemonTokenPath() (string, error) {
+	configDir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "daemon.token"), nil
+}
+
+// newDaemonClient creates a client for the local daemon's control API. Without the
+// daemon's token, requests fail as if it weren't running.
+func newDaemonClient(cfg *config.Config) *daemon.Client {
+	var token string
+	path, err := daemonTokenPath()
+	if err == nil {
+		token, err = daemon.ReadToken(path)
+	}
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	return daemon.NewClient(cfg.DaemonAddress(), token)
+}
+
+// daemonRunning reports whether the local daemon answers on its control API.
+func daemonRunning(cfg *config.Config) bool {
+	path, err := daemonTokenPath()
+	if err != nil {
+		return false
+	}
+	token, err := daemon.ReadToken(path)
+	if err != nil {
+		return false
+	}
+	_, err = daemon.NewClient(cfg.DaemonAddress(), token).Limits()
+	return err == nil
+}
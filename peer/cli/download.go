@@ -1,32 +1,105 @@
 package cli
 
 import (
+	"errors"
 	"log"
 	"os"
-	"path/filepath"
+	"strings"
 
+	"github.com/ShreyamKundu/peernet/peer/bandwidth"
+	"github.com/ShreyamKundu/peernet/peer/certs"
 	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/ShreyamKundu/peernet/peer/file"
 	"github.com/ShreyamKundu/peernet/peer/p2p"
 	"github.com/spf13/cobra"
 )
 
+// downloadTarget is what the argument of 'peernet download' refers to.
+type downloadTarget struct {
+	fileHash string
+	link     *file.Link     // Set when given a peernet: link
+	manifest *file.Manifest // Set when given a manifest file
+	trackers []string       // Trackers named by the link or manifest file
+}
+
+// resolveDownloadTarget interprets a file hash, a peernet: link or the path of a
+// manifest file. Anything that parses as a file hash or link is one, even if a file
+// with that name exists.
+func resolveDownloadTarget(arg string) (*downloadTarget, error) {
+	if file.IsLink(arg) {
+		link, err := file.ParseLink(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &downloadTarget{fileHash: link.FileHash, link: link, trackers: link.Trackers}, nil
+	}
+	if _, _, err := file.ParseID(arg); err == nil {
+		return &downloadTarget{fileHash: arg}, nil
+	}
+	if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+		manifest, trackers, err := file.ReadManifest(arg)
+		if err != nil {
+			return nil, err
+		}
+		return &downloadTarget{fileHash: manifest.FileHash, manifest: manifest, trackers: trackers}, nil
+	}
+	return &downloadTarget{fileHash: arg}, nil
+}
+
+// tracksWith reports whether trackerURL is one of trackers, or trackers is empty.
+func tracksWith(trackers []string, trackerURL string) bool {
+	for _, tracker := range trackers {
+		if strings.TrimRight(tracker, "/") == strings.TrimRight(trackerURL, "/") {
+			return true
+		}
+	}
+	return len(trackers) == 0
+}
+
 var downloadCmd = &cobra.Command{
-	Use:   "download [file-hash]",
-	Short: "Download a file from the network",
-	Args:  cobra.ExactArgs(1),
+	Use:   "download [file-hash | peernet-link | manifest-file]",
+	Short: "Download a file or directory from the network",
+	Long: `Downloads a file or a shared directory into the output directory. It can be
+named by its hash, by the peernet: link 'peernet share' prints, or by a manifest file
+saved with 'peernet share --manifest-out'. A manifest file's chunk hashes are used
+instead of the tracker's, which is then only asked for peers. A directory's
+tree is recreated under its original name. Use --include and --exclude to download
+only some of its files. Patterns use shell glob syntax ('*', '?', '[...]'). A pattern
+without a slash matches any file or directory name, so --include '*.csv' fetches every
+CSV file and --exclude raw skips every directory called raw. A pattern with a slash
+matches the path relative to the shared directory, or a leading part of it, so
+--include images/2024 fetches everything under images/2024/.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg, err := config.Load()
 		if err != nil || cfg.AuthToken == "" {
 			log.Fatal("Configuration not found. Please run 'peernet register' first.")
 		}
 
-		fileHash := args[0]
+		target, err := resolveDownloadTarget(args[0])
+		if err != nil {
+			log.Fatalf("Invalid download target: %v", err)
+		}
+		fileHash := target.fileHash
+		if link := target.link; link != nil && link.Name != "" {
+			size := "unknown size"
+			if link.Size >= 0 {
+				size = bandwidth.FormatSize(link.Size)
+			}
+			log.Printf("Downloading %s (%s).", link.Name, size)
+		}
+		// Registrations, and so auth tokens, are per tracker, so only ours can be asked.
+		if !tracksWith(target.trackers, cfg.TrackerURL) {
+			log.Printf("Warning: this file is announced to %s, but this peer is registered with %s. Peers may not be found.",
+				strings.Join(target.trackers, ", "), cfg.TrackerURL)
+		}
+
 		outputDir, _ := cmd.Flags().GetString("output")
 		if err := os.MkdirAll(outputDir, os.ModePerm); err != nil {
 			log.Fatalf("Failed to create output directory: %v", err)
 		}
 
-		trackerClient := p2p.NewTrackerClient(cfg.TrackerURL, cfg.AuthToken)
+		trackerClient := newTrackerClient(cfg)
 		lookupResult, err := trackerClient.Lookup(fileHash)
 		if err != nil {
 			log.Fatalf("Failed to lookup file: %v", err)
@@ -34,21 +107,83 @@ var downloadCmd = &cobra.Command{
 		if len(lookupResult.Chunks) == 0 {
 			log.Fatalf("No peers found for file hash: %s", fileHash)
 		}
+		if target.manifest != nil {
+			lookupResult.Manifest = target.manifest
+		}
+		if link := target.link; link != nil && link.Size >= 0 && lookupResult.Manifest != nil && lookupResult.Manifest.FileSize != link.Size {
+			log.Fatalf("The tracker's manifest gives a size of %d bytes, but the link says %d.", lookupResult.Manifest.FileSize, link.Size)
+		}
+
+		// Flags override the config file, which overrides the downloader's defaults.
+		opts := p2p.DownloadOptions{Workers: cfg.DownloadWorkers, PerPeerLimit: cfg.DownloadPerPeerLimit}
+		if cmd.Flags().Changed("workers") {
+			opts.Workers, _ = cmd.Flags().GetInt("workers")
+		}
+		if cmd.Flags().Changed("per-peer") {
+			opts.PerPeerLimit, _ = cmd.Flags().GetInt("per-peer")
+		}
+		orderName := cfg.DownloadOrder
+		if cmd.Flags().Changed("order") {
+			orderName, _ = cmd.Flags().GetString("order")
+		}
+		if opts.Order, err = p2p.ParseChunkOrder(orderName); err != nil {
+			log.Fatalf("Invalid chunk order: %v", err)
+		}
+		if opts.RateLimit, err = rateSetting(cmd, "download-limit", cfg.DownloadLimit); err != nil {
+			log.Fatalf("Invalid download limit: %v", err)
+		}
+		if opts.PerPeerRateLimit, err = rateSetting(cmd, "download-limit-per-peer", cfg.DownloadLimitPerPeer); err != nil {
+			log.Fatalf("Invalid download limit: %v", err)
+		}
 
-		downloader := p2p.NewDownloader(trackerClient)
-		outputPath := filepath.Join(outputDir, fileHash+".download") // Define the final output path
+		if opts.Credentials, err = certs.ClientCredentials(cfg.PeerTLS); err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+
+		opts.Include, _ = cmd.Flags().GetStringArray("include")
+		opts.Exclude, _ = cmd.Flags().GetStringArray("exclude")
+		for _, patterns := range [][]string{opts.Include, opts.Exclude} {
+			if err := file.ValidatePatterns(patterns); err != nil {
+				log.Fatalf("Invalid file pattern: %v", err)
+			}
+		}
+
+		if noStore, _ := cmd.Flags().GetBool("no-store"); !noStore {
+			if opts.Store, err = openChunkStore(); err != nil {
+				log.Printf("Warning: downloading without the chunk store: %v", err)
+			}
+		}
+		// The daemon serves chunks from the store, so other peers can fetch them from it
+		// before the download finishes.
+		if opts.Store != nil && daemonRunning(cfg) {
+			opts.AnnounceProgress = true
+			log.Println("Announcing downloaded chunks to the tracker as they arrive; the daemon serves them.")
+		}
 
-		// Pass the outputPath to the DownloadFile function
-		if err := downloader.DownloadFile(fileHash, lookupResult, outputPath); err != nil {
+		downloader := p2p.NewDownloader(trackerClient, opts)
+		defer downloader.Close()
+		outputPath, err := downloader.DownloadFile(fileHash, lookupResult, outputDir)
+		if errors.Is(err, p2p.ErrFileHashMismatch) || errors.Is(err, p2p.ErrNoFilesSelected) {
 			log.Fatalf("Failed to download file: %v", err)
 		}
+		if err != nil {
+			log.Fatalf("Failed to download file: %v. Run the same command again to resume.", err)
+		}
 
 		// The file is already written to disk by DownloadFile, no need for os.WriteFile here.
-		log.Printf("✅ File successfully downloaded to %s", outputPath)
+		log.Printf("✅ File successfully downloaded and verified: %s", outputPath)
 	},
 }
 
 func init() {
 	downloadCmd.Flags().StringP("output", "o", "./downloads", "Directory to save downloaded files")
+	downloadCmd.Flags().IntP("workers", "w", p2p.DefaultWorkers, "Maximum number of chunks to download at once")
+	downloadCmd.Flags().Int("per-peer", p2p.DefaultPerPeerLimit, "Maximum number of concurrent chunk requests to a single peer")
+	downloadCmd.Flags().String("download-limit", "", "Total download bandwidth limit, e.g. 10M (0 for unlimited)")
+	downloadCmd.Flags().String("download-limit-per-peer", "", "Download bandwidth limit for each peer, e.g. 1M (0 for unlimited)")
+	downloadCmd.Flags().Bool("no-store", false, "Don't keep downloaded chunks in the local chunk store")
+	downloadCmd.Flags().StringArray("include", nil, "Only download the files of a directory matching this pattern (repeatable)")
+	downloadCmd.Flags().StringArray("exclude", nil, "Skip the files of a directory matching this pattern (repeatable)")
+	downloadCmd.Flags().String("order", p2p.DefaultChunkOrder, "Chunk selection policy: sequential, random or rarest-first")
 	rootCmd.AddCommand(downloadCmd)
 }
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/ShreyamKundu/peernet/peer/bandwidth"
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/ShreyamKundu/peernet/peer/store"
+	"github.com/spf13/cobra"
+)
+
+// openChunkStore opens the local chunk store in ~/.peernet/store.
+func openChunkStore() (*store.Store, error) {
+	configDir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(filepath.Join(configDir, "store"))
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Reclaim space in the local chunk store",
+	Long: `Deletes chunks in ~/.peernet/store that no downloaded file references any more.
+A downloaded file stops referencing its chunks once it is deleted, or when it is named
+with --release. If the store is still larger than the quota, the least recently
+downloaded files are dropped from it, along with every chunk no other file uses, until
+it fits.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil || cfg.AuthToken == "" {
+			log.Fatal("Configuration not found. Please run 'peernet register' first.")
+		}
+
+		quotaSetting := cfg.StoreQuota
+		if cmd.Flags().Changed("quota") {
+			quotaSetting, _ = cmd.Flags().GetString("quota")
+		}
+		quota, err := bandwidth.ParseSize(quotaSetting)
+		if err != nil {
+			log.Fatalf("Invalid store quota: %v", err)
+		}
+
+		st, err := openChunkStore()
+		if err != nil {
+			log.Fatalf("Failed to open chunk store: %v", err)
+		}
+		release, _ := cmd.Flags().GetStringArray("release")
+		for _, arg := range release {
+			target, err := resolveDownloadTarget(arg)
+			if err != nil {
+				log.Fatalf("Invalid file to release: %v", err)
+			}
+			if err := st.Release(target.fileHash); err != nil {
+				log.Fatalf("Failed to release file %s: %v", target.fileHash, err)
+			}
+			log.Printf("Released file %s from the chunk store.", target.fileHash)
+		}
+
+		result, err := st.GC(quota)
+		if err != nil {
+			log.Fatalf("Garbage collection failed: %v", err)
+		}
+		for _, fileHash := range result.RemovedFiles {
+			log.Printf("Released file %s from the chunk store, as its download was deleted.", fileHash)
+		}
+		for _, fileHash := range result.ReleasedFiles {
+			log.Printf("Dropped file %s from the chunk store to stay under the quota.", fileHash)
+		}
+		log.Printf("Removed %d chunk(s), freeing %s. The chunk store now holds %s.",
+			result.RemovedChunks, bandwidth.FormatSize(result.FreedBytes), bandwidth.FormatSize(result.TotalBytes))
+	},
+}
+
+func init() {
+	gcCmd.Flags().StringArray("release", nil, "File hash, peernet: link or manifest file of a download whose chunks are no longer needed (repeatable)")
+	gcCmd.Flags().String("quota", "", "Maximum size of the chunk store, e.g. 20G (default from config, else no limit)")
+	rootCmd.AddCommand(gcCmd)
+}
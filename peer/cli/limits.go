@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ShreyamKundu/peernet/peer/bandwidth"
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/ShreyamKundu/peernet/peer/daemon"
+	"github.com/spf13/cobra"
+)
+
+var limitsCmd = &cobra.Command{
+	Use:   "limits",
+	Short: "Show or change the seeding daemon's upload bandwidth limits",
+	Long: `Without flags, prints the running daemon's upload limits. With --upload-limit or
+--upload-limit-per-peer, changes them immediately. Rates accept suffixes such as
+512K, 10M or 1G (bytes per second); 0 means unlimited.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil || cfg.AuthToken == "" {
+			log.Fatal("Configuration not found. Please run 'peernet register' first.")
+		}
+
+		client := newDaemonClient(cfg)
+		update, err := uploadLimitFlags(cmd)
+		if err != nil {
+			log.Fatalf("Invalid upload limit: %v", err)
+		}
+
+		var limits *daemon.Limits
+		if update.UploadLimit != nil || update.UploadLimitPerPeer != nil {
+			limits, err = client.SetLimits(update)
+		} else {
+			limits, err = client.Limits()
+		}
+		if err != nil {
+			log.Fatalf("Failed to update limits: %v", err)
+		}
+		fmt.Printf("Upload limit:          %s\n", bandwidth.FormatRate(*limits.UploadLimit))
+		fmt.Printf("Upload limit per peer: %s\n", bandwidth.FormatRate(*limits.UploadLimitPerPeer))
+	},
+}
+
+// addUploadLimitFlags registers the upload limit flags on a command.
+func addUploadLimitFlags(cmd *cobra.Command) {
+	cmd.Flags().String("upload-limit", "", "Total upload bandwidth limit, e.g. 10M (0 for unlimited)")
+	cmd.Flags().String("upload-limit-per-peer", "", "Upload bandwidth limit for each remote peer, e.g. 1M (0 for unlimited)")
+}
+
+// uploadLimitFlags returns the upload limits given on the command line. Flags that were
+// not set are left nil so the daemon keeps its current value.
+func uploadLimitFlags(cmd *cobra.Command) (daemon.Limits, error) {
+	var update daemon.Limits
+	for flag, target := range map[string]**int64{
+		"upload-limit":          &update.UploadLimit,
+		"upload-limit-per-peer": &update.UploadLimitPerPeer,
+	} {
+		if !cmd.Flags().Changed(flag) {
+			continue
+		}
+		value, _ := cmd.Flags().GetString(flag)
+		rate, err := bandwidth.ParseRate(value)
+		if err != nil {
+			return update, err
+		}
+		*target = &rate
+	}
+	return update, nil
+}
+
+// rateSetting resolves a bandwidth limit from a flag, falling back to the config file value.
+func rateSetting(cmd *cobra.Command, flag, configValue string) (int64, error) {
+	value := configValue
+	if cmd.Flags().Changed(flag) {
+		value, _ = cmd.Flags().GetString(flag)
+	}
+	return bandwidth.ParseRate(value)
+}
+
+func init() {
+	addUploadLimitFlags(limitsCmd)
+	rootCmd.AddCommand(limitsCmd)
+}
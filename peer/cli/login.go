@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/spf13/cobra"
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to the tracker as an existing peer and save new credentials",
+	Long: `Gets new tokens from the tracker for an existing peer account, keeping its peer ID,
+reputation and token balance. Use it when the saved tokens have expired or were revoked,
+or to use the account on another machine with --peer-id.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		password, _ := cmd.Flags().GetString("password")
+		if password == "" {
+			log.Fatal("Must provide --password")
+		}
+
+		cfg, err := config.Load()
+		if err != nil {
+			cfg = &config.Config{}
+		}
+		if cmd.Flags().Changed("tracker") {
+			cfg.TrackerURL, _ = cmd.Flags().GetString("tracker")
+		}
+		if cmd.Flags().Changed("peer-id") {
+			cfg.PeerID, _ = cmd.Flags().GetString("peer-id")
+		}
+		if cmd.Flags().Changed("tracker-ca") {
+			cfg.TrackerTLS.CAFile, _ = cmd.Flags().GetString("tracker-ca")
+		}
+		if cmd.Flags().Changed("tracker-cert-sha256") {
+			cfg.TrackerTLS.CertSHA256, _ = cmd.Flags().GetString("tracker-cert-sha256")
+		}
+		if cfg.TrackerURL == "" || cfg.PeerID == "" {
+			log.Fatal("No peer account configured. Pass --tracker and --peer-id, or run 'peernet register' first.")
+		}
+
+		tokens, err := newTrackerClient(cfg).Login(cfg.PeerID, password)
+		if err != nil {
+			log.Fatalf("Failed to log in to tracker: %v", err)
+		}
+
+		cfg.AuthToken = tokens.Token
+		cfg.RefreshToken = tokens.RefreshToken
+		if err := cfg.Save(); err != nil {
+			log.Fatalf("Failed to save configuration: %v", err)
+		}
+
+		fmt.Printf("✅ Logged in as peer %s. Configuration saved.\n", cfg.PeerID)
+	},
+}
+
+func init() {
+	loginCmd.Flags().String("tracker", "", "URL of the tracker server (default from config)")
+	loginCmd.Flags().String("peer-id", "", "Peer ID of the account (default from config)")
+	loginCmd.Flags().String("password", "", "The password of the peer account")
+	loginCmd.Flags().String("tracker-ca", "", "CA certificate (or self-signed tracker certificate) to trust for an HTTPS tracker")
+	loginCmd.Flags().String("tracker-cert-sha256", "", "Pin the HTTPS tracker's certificate by its SHA-256 fingerprint")
+	rootCmd.AddCommand(loginCmd)
+}
@@ -0,0 +1,41 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/spf13/cobra"
+)
+
+var passwordCmd = &cobra.Command{
+	Use:   "password",
+	Short: "Change the password of this peer's tracker account",
+	Long: `Changes the password used by 'peernet login'. The tracker revokes the refresh
+tokens of every other session of the account, so other machines logged in as this
+peer have to log in again once their access tokens expire.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil || cfg.AuthToken == "" {
+			log.Fatal("Configuration not found. Please run 'peernet register' first.")
+		}
+
+		current, _ := cmd.Flags().GetString("current")
+		newPassword, _ := cmd.Flags().GetString("new")
+		if current == "" || newPassword == "" {
+			log.Fatal("Must provide --current and --new")
+		}
+
+		if _, err := newTrackerClient(cfg).ChangePassword(current, newPassword); err != nil {
+			log.Fatalf("Failed to change password: %v", err)
+		}
+		fmt.Println("✅ Password changed. New credentials saved.")
+	},
+}
+
+func init() {
+	passwordCmd.Flags().String("current", "", "The current password")
+	passwordCmd.Flags().String("new", "", "The new password")
+	rootCmd.AddCommand(passwordCmd)
+}
@@ -1,11 +1,8 @@
 package cli
 
 import (
-	"bytes"
-	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 
 	"github.com/ShreyamKundu/peernet/peer/config"
 	"github.com/spf13/cobra"
@@ -23,26 +20,29 @@ var registerCmd = &cobra.Command{
 			log.Fatal("Must provide --tracker, --address, and --password")
 		}
 
-		payload := map[string]string{"address": address, "password": password}
-		body, _ := json.Marshal(payload)
-		resp, err := http.Post(trackerURL+"/api/v1/peers/register", "application/json", bytes.NewBuffer(body))
+		// Keep any other settings (such as daemon_addr) from an existing configuration.
+		cfg, err := config.Load()
 		if err != nil {
-			log.Fatalf("Failed to register with tracker: %v", err)
+			cfg = &config.Config{}
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusCreated {
-			log.Fatalf("Registration failed with status: %s", resp.Status)
+		cfg.TrackerURL = trackerURL
+		cfg.AuthToken = ""
+		cfg.RefreshToken = ""
+		if cmd.Flags().Changed("tracker-ca") {
+			cfg.TrackerTLS.CAFile, _ = cmd.Flags().GetString("tracker-ca")
+		}
+		if cmd.Flags().Changed("tracker-cert-sha256") {
+			cfg.TrackerTLS.CertSHA256, _ = cmd.Flags().GetString("tracker-cert-sha256")
 		}
 
-		var result map[string]string
-		json.NewDecoder(resp.Body).Decode(&result)
-		token := result["token"]
-
-		cfg := &config.Config{
-			TrackerURL: trackerURL,
-			AuthToken:  token,
+		tokens, err := newTrackerClient(cfg).Register(address, password)
+		if err != nil {
+			log.Fatalf("Failed to register with tracker: %v", err)
 		}
+
+		cfg.AuthToken = tokens.Token
+		cfg.RefreshToken = tokens.RefreshToken
+		cfg.PeerID = tokens.PeerID
 		if err := cfg.Save(); err != nil {
 			log.Fatalf("Failed to save configuration: %v", err)
 		}
@@ -55,5 +55,7 @@ func init() {
 	registerCmd.Flags().String("tracker", "", "URL of the tracker server")
 	registerCmd.Flags().String("address", "", "This peer's public IP and port (e.g., 123.45.67.89:50051)")
 	registerCmd.Flags().String("password", "", "A password for your peer account")
+	registerCmd.Flags().String("tracker-ca", "", "CA certificate (or self-signed tracker certificate) to trust for an HTTPS tracker")
+	registerCmd.Flags().String("tracker-cert-sha256", "", "Pin the HTTPS tracker's certificate by its SHA-256 fingerprint")
 	rootCmd.AddCommand(registerCmd)
 }
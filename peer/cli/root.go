@@ -14,6 +14,10 @@ var rootCmd = &cobra.Command{
 
 Before you begin, register with a tracker:
   peernet register --tracker http://your-tracker.com --address your-ip:50051 --password your-pass
+
+Then start the seeding daemon and add files to it:
+  peernet serve --port 50051
+  peernet share ./my-file.iso
 `,
 }
 
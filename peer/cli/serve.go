@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ShreyamKundu/peernet/peer/bandwidth"
+	"github.com/ShreyamKundu/peernet/peer/certs"
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/ShreyamKundu/peernet/peer/daemon"
+	"github.com/ShreyamKundu/peernet/peer/file"
+	"github.com/ShreyamKundu/peernet/peer/p2p"
+	"github.com/spf13/cobra"
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run the seeding daemon that serves all shared files",
+	Long: `Starts a long-running daemon that serves every shared file on a single gRPC port.
+Use 'peernet share' and 'peernet unshare' to change what it serves while it runs.
+On SIGINT or SIGTERM it withdraws its announcements from the tracker before exiting,
+and announces its shared files again when it next starts.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil || cfg.AuthToken == "" {
+			log.Fatal("Configuration not found. Please run 'peernet register' first.")
+		}
+
+		grpcPort, _ := cmd.Flags().GetString("port")
+		controlAddr, _ := cmd.Flags().GetString("control")
+		if controlAddr == "" {
+			controlAddr = cfg.DaemonAddress()
+		}
+
+		configDir, err := config.Dir()
+		if err != nil {
+			log.Fatalf("Failed to locate configuration directory: %v", err)
+		}
+
+		uploadLimit, err := rateSetting(cmd, "upload-limit", cfg.UploadLimit)
+		if err != nil {
+			log.Fatalf("Invalid upload limit: %v", err)
+		}
+		uploadLimitPerPeer, err := rateSetting(cmd, "upload-limit-per-peer", cfg.UploadLimitPerPeer)
+		if err != nil {
+			log.Fatalf("Invalid upload limit: %v", err)
+		}
+
+		creds, err := certs.ServerCredentials(cfg.PeerTLS)
+		if err != nil {
+			log.Fatalf("Failed to set up TLS: %v", err)
+		}
+
+		trackerClient := newTrackerClient(cfg)
+		grpcServer := p2p.NewGRPCServer()
+		grpcServer.SetPeerID(cfg.PeerID)
+		grpcServer.UploadLimiter().SetLimits(uploadLimit, uploadLimitPerPeer)
+		log.Printf("Upload limits: %s total, %s per peer", bandwidth.FormatRate(uploadLimit), bandwidth.FormatRate(uploadLimitPerPeer))
+		if st, err := openChunkStore(); err != nil {
+			log.Printf("Warning: not serving chunks from the chunk store: %v", err)
+		} else {
+			grpcServer.SetStore(st)
+		}
+		hashes := file.OpenHashCache(filepath.Join(configDir, "hashcache.json"))
+		d := daemon.New(grpcServer, trackerClient, filepath.Join(configDir, "shares.json"), hashes)
+		if err := d.Restore(); err != nil {
+			log.Printf("Warning: failed to restore shared files: %v", err)
+		}
+
+		go func() {
+			log.Printf("Starting gRPC server to serve file chunks on port %s...", grpcPort)
+			if err := grpcServer.Start(grpcPort, creds); err != nil {
+				log.Fatalf("Failed to start gRPC server: %v", err)
+			}
+		}()
+		tokenPath, err := daemonTokenPath()
+		if err != nil {
+			log.Fatalf("Failed to locate configuration directory: %v", err)
+		}
+		controlListener, err := d.Listen(controlAddr, tokenPath)
+		if err != nil {
+			log.Fatalf("Failed to start daemon control API: %v", err)
+		}
+		go func() {
+			if err := d.Serve(controlListener); err != nil {
+				log.Fatalf("Daemon control API failed: %v", err)
+			}
+		}()
+		// Restored files were withdrawn from the tracker when the daemon last stopped.
+		var announcers sync.WaitGroup
+		announcers.Add(2)
+		go func() {
+			defer announcers.Done()
+			d.AnnounceAll()
+		}()
+		heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+		go func() {
+			defer announcers.Done()
+			d.Heartbeat(heartbeatCtx)
+		}()
+
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		<-quit
+		log.Println("Shutting down daemon...")
+		// Stop taking shares and heartbeats first, so nothing announces after the withdraw.
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := d.Shutdown(ctx); err != nil {
+			log.Printf("Control API forced to shutdown: %v", err)
+		}
+		stopHeartbeat()
+		announcers.Wait()
+		if err := d.Withdraw(); err != nil {
+			log.Printf("Warning: failed to withdraw announcements from tracker: %v", err)
+		} else {
+			log.Println("Withdrew all announcements from tracker.")
+		}
+		grpcServer.Stop()
+		log.Println("Daemon exiting")
+	},
+}
+
+func init() {
+	serveCmd.Flags().StringP("port", "p", "50051", "Port for this peer to listen for requests")
+	addUploadLimitFlags(serveCmd)
+	serveCmd.Flags().String("control", "", "Local address for the daemon control API (default from config, or "+config.DefaultDaemonAddr+")")
+	rootCmd.AddCommand(serveCmd)
+}
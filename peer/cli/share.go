@@ -1,61 +1,182 @@
 package cli
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/ShreyamKundu/peernet/peer/daemon"
 	"github.com/ShreyamKundu/peernet/peer/file"
-	"github.com/ShreyamKundu/peernet/peer/p2p"
 	"github.com/spf13/cobra"
 )
 
 var shareCmd = &cobra.Command{
-	Use:   "share [file-path]",
-	Short: "Share a file on the network",
-	Args:  cobra.ExactArgs(1),
+	Use:   "share [path]",
+	Short: "Share a file or directory on the network",
+	Long: `Adds a file to the running seeding daemon, which chunks it, announces it to
+the tracker and starts serving it. Start the daemon first with 'peernet serve'.
+
+A directory is shared as a single swarm under one hash. Every regular file under it
+is included, and downloading the hash recreates the directory tree.
+
+The file's peernet: link is printed on standard output. It carries the hash, name,
+size and tracker, and can be passed to 'peernet download' in place of the hash. With
+--manifest-out the full manifest is saved too, so downloaders holding it don't need
+the tracker for anything but finding peers.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg, err := config.Load()
 		if err != nil || cfg.AuthToken == "" {
 			log.Fatal("Configuration not found. Please run 'peernet register' first.")
 		}
 
-		filePath := args[0]
+		// The daemon resolves paths relative to its own working directory, so always send an absolute path.
+		filePath, err := filepath.Abs(args[0])
+		if err != nil {
+			log.Fatalf("Invalid file path %s: %v", args[0], err)
+		}
 		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			log.Fatalf("File does not exist: %s", filePath)
+			log.Fatalf("File or directory does not exist: %s", filePath)
+		}
+
+		client := newDaemonClient(cfg)
+
+		// Upload limits given here apply to the whole daemon, immediately.
+		update, err := uploadLimitFlags(cmd)
+		if err != nil {
+			log.Fatalf("Invalid upload limit: %v", err)
+		}
+		if update.UploadLimit != nil || update.UploadLimitPerPeer != nil {
+			if _, err := client.SetLimits(update); err != nil {
+				log.Fatalf("Failed to update upload limits: %v", err)
+			}
+		}
+
+		req := daemon.ShareRequest{Path: filePath, HashAlgorithm: cfg.HashAlgorithm, Chunking: cfg.Chunking}
+		if cmd.Flags().Changed("hash") {
+			req.HashAlgorithm, _ = cmd.Flags().GetString("hash")
+		}
+		if cmd.Flags().Changed("chunking") {
+			req.Chunking, _ = cmd.Flags().GetString("chunking")
+		}
+
+		shared, err := client.Share(req)
+		if err != nil {
+			log.Fatalf("Failed to share file: %v", err)
+		}
+		if len(shared.Files) > 0 {
+			log.Printf("Directory '%s' is now being served (%d files, %d chunks). Hash: %s", shared.Path, len(shared.Files), shared.TotalChunks, shared.FileHash)
+		} else {
+			log.Printf("File '%s' is now being served (%d chunks). File Hash: %s", shared.Path, shared.TotalChunks, shared.FileHash)
 		}
 
-		// Chunk the file. `chunks` here still contains the data, which is fine for announcement.
-		chunks, fileHash, err := file.ChunkFile(filePath)
+		// The link and the manifest file are both made from the daemon's manifest, so they agree.
+		trackers := []string{cfg.TrackerURL}
+		manifest, err := client.Manifest(shared.FileHash)
 		if err != nil {
-			log.Fatalf("Failed to chunk file: %v", err)
+			log.Fatalf("Failed to get manifest: %v", err)
+		}
+		if manifestPath, _ := cmd.Flags().GetString("manifest-out"); manifestPath != "" {
+			if err := file.WriteManifest(manifestPath, manifest, trackers); err != nil {
+				log.Fatalf("Failed to save manifest: %v", err)
+			}
+			log.Printf("Manifest saved to %s", manifestPath)
+		}
+		// The link goes to stdout, apart from the log, so scripts can capture it.
+		fmt.Println(file.NewLink(manifest, trackers))
+	},
+}
+
+var unshareCmd = &cobra.Command{
+	Use:   "unshare [file-hash]",
+	Short: "Stop sharing a file and withdraw it from the tracker",
+	Long: `Stops the seeding daemon serving a file and withdraws this peer's chunks of it
+from the tracker. If the daemon isn't running or isn't serving the file, the tracker is
+still told, so a peer that stopped uncleanly can clear announcements it left behind.
+With --all, every file is unshared and withdrawn.`,
+	Args: cobra.RangeArgs(0, 1),
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil || cfg.AuthToken == "" {
+			log.Fatal("Configuration not found. Please run 'peernet register' first.")
 		}
-		log.Printf("File '%s' chunked successfully. File Hash: %s", filePath, fileHash)
 
-		client := p2p.NewTrackerClient(cfg.TrackerURL, cfg.AuthToken)
-		// Announce each chunk to the tracker
-		for i := range chunks {
-			if err := client.Announce(filePath, fileHash, len(chunks), i, chunks[i].Hash); err != nil {
-				log.Printf("Failed to announce chunk %d: %v", i, err)
+		all, _ := cmd.Flags().GetBool("all")
+		if all == (len(args) == 1) {
+			log.Fatal("Specify either a file hash or --all.")
+		}
+
+		client := newDaemonClient(cfg)
+		trackerClient := newTrackerClient(cfg)
+		if all {
+			if files, err := client.List(); err != nil {
+				log.Printf("Warning: %v", err)
+			} else {
+				for _, f := range files {
+					if err := client.Unshare(f.FileHash); err != nil {
+						log.Printf("Warning: failed to unshare %s: %v", f.Path, err)
+					}
+				}
+			}
+			if err := trackerClient.UnannounceAll(); err != nil {
+				log.Fatalf("Failed to withdraw files from tracker: %v", err)
 			}
+			log.Println("No files are being served or announced.")
+			return
+		}
+
+		// The daemon withdraws the file from the tracker itself when it unshares it.
+		err = client.Unshare(args[0])
+		if err == nil {
+			log.Printf("File %s is no longer being served.", args[0])
+			return
+		}
+		log.Printf("Warning: %v", err)
+		if err := trackerClient.Unannounce(args[0]); err != nil {
+			log.Fatalf("Failed to withdraw file from tracker: %v", err)
 		}
-		log.Printf("Announced all %d chunks to tracker.", len(chunks))
+		log.Printf("Withdrew file %s from the tracker.", args[0])
+	},
+}
 
-		grpcPort, _ := cmd.Flags().GetString("port")
+var sharesCmd = &cobra.Command{
+	Use:   "shares",
+	Short: "List the files the seeding daemon is serving",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load()
+		if err != nil || cfg.AuthToken == "" {
+			log.Fatal("Configuration not found. Please run 'peernet register' first.")
+		}
 
-		// IMPORTANT: The gRPC server is now initialized with the file path and chunk metadata,
-		// but it will read the actual chunk data from disk on demand.
-		// Note: For a multi-file sharing peer, this `NewGRPCServer` call would need to
-		// manage multiple shared files. For this demo, it assumes one file at a time.
-		grpcServer := p2p.NewGRPCServer(filePath, fileHash, len(chunks), chunks)
-		log.Printf("Starting gRPC server to serve file chunks on port %s...", grpcPort)
-		if err := grpcServer.Start(grpcPort); err != nil {
-			log.Fatalf("Failed to start gRPC server: %v", err)
+		files, err := newDaemonClient(cfg).List()
+		if err != nil {
+			log.Fatalf("Failed to list shared files: %v", err)
+		}
+		if len(files) == 0 {
+			fmt.Println("No files are being shared.")
+			return
+		}
+		for _, f := range files {
+			if len(f.Files) > 0 {
+				fmt.Printf("%s  %6d chunks  %s/ (%d files)\n", f.FileHash, f.TotalChunks, f.Path, len(f.Files))
+				continue
+			}
+			fmt.Printf("%s  %6d chunks  %s\n", f.FileHash, f.TotalChunks, f.Path)
 		}
 	},
 }
 
 func init() {
-	shareCmd.Flags().StringP("port", "p", "50051", "Port for this peer to listen for requests")
+	addUploadLimitFlags(shareCmd)
+	shareCmd.Flags().String("hash", "", "Hash algorithm for chunk hashes and the file ID: "+strings.Join(file.HashAlgorithmNames(), ", ")+" (default from config, else sha256)")
+	shareCmd.Flags().String("chunking", "", "How to split files: fixed (1 MB chunks) or fastcdc (content-defined chunks shared across versions of a file) (default from config, else fixed)")
+	shareCmd.Flags().String("manifest-out", "", "Also save the file's manifest to this path, for 'peernet download'")
 	rootCmd.AddCommand(shareCmd)
+	unshareCmd.Flags().Bool("all", false, "Unshare every file and withdraw all of this peer's announcements")
+	rootCmd.AddCommand(unshareCmd)
+	rootCmd.AddCommand(sharesCmd)
 }
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"log"
+
+	"github.com/ShreyamKundu/peernet/peer/certs"
+	"github.com/ShreyamKundu/peernet/peer/config"
+	"github.com/ShreyamKundu/peernet/peer/p2p"
+)
+
+// newTrackerClient creates a tracker client using the configured credentials and TLS
+// settings. Refreshed tokens are saved back to the configuration file.
+func newTrackerClient(cfg *config.Config) *p2p.TrackerClient {
+	tlsConfig, err := certs.TrackerTLSConfig(cfg.TrackerTLS)
+	if err != nil {
+		log.Fatalf("Failed to set up tracker TLS: %v", err)
+	}
+	client := p2p.NewTrackerClient(cfg.TrackerURL, cfg.AuthToken, tlsConfig)
+	client.SetTokenStore(configTokenStore{})
+	return client
+}
+
+// configTokenStore keeps the tracker tokens in the configuration file, which the daemon
+// and every command share. It is reread each time, as another process may have
+// refreshed the tokens since this one loaded the configuration.
+type configTokenStore struct{}
+
+func (configTokenStore) LockTokens() (func(), error) {
+	return config.Lock()
+}
+
+func (configTokenStore) LoadTokens() (string, string, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return "", "", err
+	}
+	return cfg.AuthToken, cfg.RefreshToken, nil
+}
+
+func (configTokenStore) SaveTokens(token, refreshToken string) error {
+	cfg, err := config.Load()
+	if err != nil {
+		return err
+	}
+	cfg.AuthToken = token
+	cfg.RefreshToken = refreshToken
+	return cfg.Save()
+}
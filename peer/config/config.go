@@ -7,20 +7,96 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// DefaultDaemonAddr is the local address the `serve` daemon listens on for control requests.
+const DefaultDaemonAddr = "127.0.0.1:50050"
+
+// PeerTLS configures TLS for peer-to-peer chunk transfers. With no certificate and
+// no CA configured, transfers use plaintext gRPC.
+type PeerTLS struct {
+	CertFile string `yaml:"cert_file,omitempty"` // This peer's certificate, issued for its peer ID
+	KeyFile  string `yaml:"key_file,omitempty"`
+	CAFile   string `yaml:"ca_file,omitempty"` // CA that peer certificates must chain to
+	// Mutual requires every peer to present a certificate, both when serving and downloading.
+	Mutual bool `yaml:"mutual,omitempty"`
+}
+
+// Enabled reports whether TLS is configured.
+func (t PeerTLS) Enabled() bool {
+	return t.CertFile != "" || t.CAFile != ""
+}
+
+// TrackerTLS configures how the peer verifies an HTTPS tracker. With nothing set, the
+// tracker's certificate is checked against the system roots.
+type TrackerTLS struct {
+	// CAFile is the CA (or the tracker's self-signed certificate) to trust instead of the system roots.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// CertSHA256 pins the tracker's certificate by its hex-encoded SHA-256 fingerprint.
+	CertSHA256 string `yaml:"cert_sha256,omitempty"`
+	// Client certificate, for trackers that require one.
+	CertFile string `yaml:"cert_file,omitempty"`
+	KeyFile  string `yaml:"key_file,omitempty"`
+}
+
 type Config struct {
 	TrackerURL string `yaml:"tracker_url"`
 	AuthToken  string `yaml:"auth_token"`
+	PeerID     string `yaml:"peer_id,omitempty"`
+	DaemonAddr string `yaml:"daemon_addr,omitempty"`
+
+	// RefreshToken is exchanged for a new auth token when the tracker rejects the old one.
+	RefreshToken string `yaml:"refresh_token,omitempty"`
+
+	PeerTLS    PeerTLS    `yaml:"peer_tls,omitempty"`
+	TrackerTLS TrackerTLS `yaml:"tracker_tls,omitempty"`
+
+	// Hash algorithm for newly shared files: sha256 (the default), blake3 or sha512-256.
+	HashAlgorithm string `yaml:"hash_algorithm,omitempty"`
+	// Chunking for newly shared files: fixed (the default) or fastcdc for content-defined
+	// chunks that let different versions of a file share most of their chunks.
+	Chunking string `yaml:"chunking,omitempty"`
+
+	// Download concurrency. Zero means use the downloader's defaults.
+	DownloadWorkers      int `yaml:"download_workers,omitempty"`
+	DownloadPerPeerLimit int `yaml:"download_per_peer_limit,omitempty"`
+	// Chunk selection policy: sequential, random or rarest-first (the default).
+	DownloadOrder string `yaml:"download_order,omitempty"`
+
+	// Largest size the chunk store may grow to before 'peernet gc' evicts the least
+	// recently downloaded files, such as "20G". Empty means no limit.
+	StoreQuota string `yaml:"store_quota,omitempty"`
+
+	// Bandwidth limits in bytes per second, such as "10M" or "512K". Empty means unlimited.
+	UploadLimit          string `yaml:"upload_limit,omitempty"`
+	UploadLimitPerPeer   string `yaml:"upload_limit_per_peer,omitempty"`
+	DownloadLimit        string `yaml:"download_limit,omitempty"`
+	DownloadLimitPerPeer string `yaml:"download_limit_per_peer,omitempty"`
 }
 
-func configFilePath() (string, error) {
+// Dir returns the directory holding the peer's configuration and local state.
+func Dir() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
 	}
-	configDir := filepath.Join(homeDir, ".peernet")
+	return filepath.Join(homeDir, ".peernet"), nil
+}
+
+func configFilePath() (string, error) {
+	configDir, err := Dir()
+	if err != nil {
+		return "", err
+	}
 	return filepath.Join(configDir, "config.yaml"), nil
 }
 
+// DaemonAddress returns the control address of the local daemon.
+func (c *Config) DaemonAddress() string {
+	if c.DaemonAddr == "" {
+		return DefaultDaemonAddr
+	}
+	return c.DaemonAddr
+}
+
 func Load() (*Config, error) {
 	path, err := configFilePath()
 	if err != nil {
@@ -59,5 +135,19 @@ func (c *Config) Save() error {
 		return err
 	}
 
-	return os.WriteFile(path, data, 0600)
+	// Write a temporary file and rename it over the configuration, so other processes
+	// never read a partly written one.
+	tmp, err := os.CreateTemp(configDir, ".config-*.yaml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
 }
\ No newline at end of file
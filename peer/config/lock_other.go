@@ -0,0 +1,10 @@
+//go:build !unix
+
+package config
+
+// Lock does nothing on platforms without flock; configuration saves are still atomic,
+// but processes refreshing the tracker tokens at the same moment may both spend the
+// same refresh token.
+func Lock() (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Lock takes an exclusive lock shared by every peernet process using the configuration
+// directory, so read-modify-write updates such as refreshing the tracker tokens don't
+// interleave. It blocks until the lock is free and returns a function releasing it.
+func Lock() (func(), error) {
+	configDir, err := Dir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(configDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(configDir, "config.lock"), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
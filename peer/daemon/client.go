@@ -0,0 +1,175 @@
+package daemon
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/ShreyamKundu/peernet/peer/file"
+	"github.com/ShreyamKundu/peernet/peer/p2p"
+)
+
+// Client talks to a running daemon's control API.
+type Client struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// NewClient creates a client for the daemon listening on addr (host:port), which
+// authenticates with the token from the daemon's token file.
+func NewClient(addr, token string) *Client {
+	return &Client{
+		baseURL: "http://" + addr,
+		token:   token,
+		// No timeout: sharing a large file blocks until it has been hashed and announced.
+		client: &http.Client{},
+	}
+}
+
+// do sends an authenticated request to the daemon, with body as JSON if not nil.
+func (c *Client) do(method, path string, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach daemon at %s (is 'peernet serve' running?): %v", c.baseURL, err)
+	}
+	return resp, nil
+}
+
+// Share asks the daemon to start serving the file at an absolute path.
+func (c *Client) Share(req ShareRequest) (*p2p.SharedFile, error) {
+	resp, err := c.do("POST", "/shares", req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, responseError("share", resp)
+	}
+
+	var shared p2p.SharedFile
+	if err := json.NewDecoder(resp.Body).Decode(&shared); err != nil {
+		return nil, err
+	}
+	return &shared, nil
+}
+
+// Unshare asks the daemon to stop serving a file.
+func (c *Client) Unshare(fileHash string) error {
+	resp, err := c.do("DELETE", "/shares/"+fileHash, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError("unshare", resp)
+	}
+	return nil
+}
+
+func responseError(op string, resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(resp.Body)
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		return fmt.Errorf("%s failed: %s", op, body.Error)
+	}
+	return fmt.Errorf("%s failed with status: %s, body: %s", op, resp.Status, string(data))
+}
+
+// Manifest returns the manifest of a file the daemon is serving.
+func (c *Client) Manifest(fileHash string) (*file.Manifest, error) {
+	resp, err := c.do("GET", "/shares/"+fileHash+"/manifest", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("get manifest", resp)
+	}
+
+	var manifest file.Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return nil, err
+	}
+	return &manifest, nil
+}
+
+// List returns the files the daemon is currently serving.
+func (c *Client) List() ([]*p2p.SharedFile, error) {
+	resp, err := c.do("GET", "/shares", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("list", resp)
+	}
+
+	var files []*p2p.SharedFile
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Limits returns the daemon's current upload limits.
+func (c *Client) Limits() (*Limits, error) {
+	resp, err := c.do("GET", "/limits", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("get limits", resp)
+	}
+
+	var limits Limits
+	if err := json.NewDecoder(resp.Body).Decode(&limits); err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
+
+// SetLimits changes the daemon's upload limits while it runs. Nil fields are left unchanged.
+func (c *Client) SetLimits(update Limits) (*Limits, error) {
+	resp, err := c.do("PUT", "/limits", update)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("set limits", resp)
+	}
+
+	var limits Limits
+	if err := json.NewDecoder(resp.Body).Decode(&limits); err != nil {
+		return nil, err
+	}
+	return &limits, nil
+}
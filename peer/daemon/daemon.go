@@ -0,0 +1,277 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/ShreyamKundu/peernet/peer/file"
+	"github.com/ShreyamKundu/peernet/peer/p2p"
+)
+
+// Daemon owns the long-running gRPC server and exposes a local control API
+// that the `share` and `unshare` commands use to change its catalog at runtime.
+type Daemon struct {
+	server        *p2p.Server
+	trackerClient *p2p.TrackerClient
+	catalogPath   string // Where the catalog is persisted so shares survive a restart
+	hashes        *file.HashCache
+
+	mu         sync.Mutex // Serialises catalog changes and persistence, and guards httpServer
+	httpServer *http.Server
+}
+
+// New creates a daemon around a gRPC server. catalogPath may be empty to disable persistence.
+// hashes may be nil, in which case every shared file is rehashed.
+func New(server *p2p.Server, trackerClient *p2p.TrackerClient, catalogPath string, hashes *file.HashCache) *Daemon {
+	if hashes == nil {
+		hashes = file.OpenHashCache("")
+	}
+	return &Daemon{
+		server:        server,
+		trackerClient: trackerClient,
+		catalogPath:   catalogPath,
+		hashes:        hashes,
+	}
+}
+
+// Restore re-adds the files recorded in the persisted catalog to the server.
+// Entries whose file no longer exists on disk are dropped.
+func (d *Daemon) Restore() error {
+	if d.catalogPath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(d.catalogPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read catalog %s: %v", d.catalogPath, err)
+	}
+
+	var entries []*p2p.SharedFile
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse catalog %s: %v", d.catalogPath, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, entry := range entries {
+		if _, err := os.Stat(entry.Path); err != nil {
+			log.Printf("Dropping %s from catalog: %v", entry.Path, err)
+			continue
+		}
+		d.server.AddFile(entry.Path, catalogManifest(entry))
+	}
+	return d.saveLocked()
+}
+
+// catalogManifest rebuilds the manifest of a persisted catalog entry. Entries saved
+// before manifests existed have no size or chunk size; those come from the file on disk
+// and the default chunk size.
+func catalogManifest(entry *p2p.SharedFile) *file.Manifest {
+	manifest := &file.Manifest{
+		FileHash:      entry.FileHash,
+		FileName:      filepath.Base(entry.Path),
+		FileSize:      entry.FileSize,
+		ChunkSize:     entry.ChunkSize,
+		HashAlgorithm: entry.HashAlgorithm,
+		Chunking:      entry.Chunking,
+		ChunkHashes:   make([]string, entry.TotalChunks),
+		ChunkSizes:    entry.ChunkSizes,
+		Files:         entry.Files,
+	}
+	if manifest.HashAlgorithm == "" {
+		manifest.HashAlgorithm = file.SHA256.Name
+	}
+	if manifest.ChunkSize == 0 {
+		manifest.ChunkSize = file.ChunkSize
+		if info, err := os.Stat(entry.Path); err == nil {
+			manifest.FileSize = info.Size()
+		}
+	}
+	for index, hash := range entry.ChunkHashes {
+		if index >= 0 && index < entry.TotalChunks {
+			manifest.ChunkHashes[index] = hash
+		}
+	}
+	return manifest
+}
+
+// Share chunks a local file or directory with the given hash algorithm and chunking,
+// announces its chunks to the tracker and adds it to the catalog. A directory is shared
+// as a single swarm, with a manifest listing every file under it.
+func (d *Daemon) Share(filePath string, alg *file.HashAlgorithm, chunking file.Chunking) (*p2p.SharedFile, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot share %s: %v", filePath, err)
+	}
+
+	var manifest *file.Manifest
+	if info.IsDir() {
+		if manifest, err = d.hashes.ChunkDirectory(filePath, alg, chunking); err != nil {
+			return nil, fmt.Errorf("failed to chunk directory: %v", err)
+		}
+		log.Printf("Directory '%s' chunked successfully (%d files). Hash: %s", filePath, len(manifest.Files), manifest.FileHash)
+	} else {
+		chunks, fileHash, err := d.hashes.ChunkFile(filePath, alg, chunking)
+		if err != nil {
+			return nil, fmt.Errorf("failed to chunk file: %v", err)
+		}
+		log.Printf("File '%s' chunked successfully. File Hash: %s", filePath, fileHash)
+		manifest = file.NewManifest(filepath.Base(filePath), fileHash, alg, chunking, chunks)
+	}
+
+	if err := d.trackerClient.AnnounceFile(manifest); err != nil {
+		return nil, fmt.Errorf("failed to announce file: %v", err)
+	}
+	log.Printf("Announced all %d chunks to tracker.", manifest.TotalChunks())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	shared := d.server.AddFile(filePath, manifest)
+	if err := d.saveLocked(); err != nil {
+		log.Printf("Warning: failed to persist catalog: %v", err)
+	}
+	return shared, nil
+}
+
+// Unshare removes a file from the catalog and withdraws its chunks from the tracker.
+// It reports whether the file was being served.
+func (d *Daemon) Unshare(fileHash string) bool {
+	d.mu.Lock()
+	if !d.server.RemoveFile(fileHash) {
+		d.mu.Unlock()
+		return false
+	}
+	if err := d.saveLocked(); err != nil {
+		log.Printf("Warning: failed to persist catalog: %v", err)
+	}
+	d.mu.Unlock()
+
+	if err := d.trackerClient.Unannounce(fileHash); err != nil {
+		log.Printf("Warning: failed to withdraw %s from tracker: %v", fileHash, err)
+	}
+	return true
+}
+
+// AnnounceAll announces every file in the catalog to the tracker, which forgets them
+// when the daemon withdraws its announcements on shutdown.
+func (d *Daemon) AnnounceAll() {
+	for _, shared := range d.server.Files() {
+		if err := d.trackerClient.AnnounceFile(shared.Manifest()); err != nil {
+			log.Printf("Warning: failed to announce %s: %v", shared.Path, err)
+			continue
+		}
+		log.Printf("Announced %s (%d chunks) to tracker.", shared.Path, shared.TotalChunks)
+	}
+}
+
+// defaultHeartbeatInterval is how often heartbeats are sent when the tracker hasn't said
+// how long it waits for them.
+const defaultHeartbeatInterval = 30 * time.Second
+
+// Heartbeat tells the tracker the daemon is alive until ctx is cancelled, sending
+// heartbeats three times per offline period the tracker reports so that one lost
+// heartbeat doesn't get the peer marked offline. If the tracker had marked the peer
+// offline, every shared file is announced again.
+func (d *Daemon) Heartbeat(ctx context.Context) {
+	interval := defaultHeartbeatInterval
+	for {
+		result, err := d.trackerClient.Heartbeat()
+		if err != nil {
+			log.Printf("Warning: heartbeat failed: %v", err)
+		} else {
+			if result.OfflineAfterSeconds > 0 {
+				interval = time.Duration(result.OfflineAfterSeconds) * time.Second / 3
+			}
+			if result.WasOffline {
+				log.Println("Tracker had marked this peer offline; announcing shared files again.")
+				d.AnnounceAll()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Withdraw tells the tracker this peer no longer serves any of its chunks, so lookups
+// stop returning it while it is down. The catalog is kept for the next start.
+func (d *Daemon) Withdraw() error {
+	return d.trackerClient.UnannounceAll()
+}
+
+// saveLocked writes the current catalog to disk. d.mu must be held.
+func (d *Daemon) saveLocked() error {
+	if d.catalogPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(d.server.Files(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.catalogPath), 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a truncated catalog behind.
+	tmpPath := d.catalogPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, d.catalogPath)
+}
+
+// Listen binds the control API to addr and writes a new control token to tokenPath,
+// which requests must carry. The token is only replaced once the address is bound, so a
+// second daemon that fails to start leaves the running one's token alone. Serve handles
+// requests on the returned listener.
+func (d *Daemon) Listen(addr, tokenPath string) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	token, err := CreateToken(tokenPath)
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to create control token: %v", err)
+	}
+	d.mu.Lock()
+	d.httpServer = &http.Server{Handler: d.routes(token)}
+	d.mu.Unlock()
+	log.Printf("Daemon control API listening on %s", ln.Addr())
+	return ln, nil
+}
+
+// Serve handles control API requests on a listener from Listen.
+// It blocks until Shutdown is called.
+func (d *Daemon) Serve(ln net.Listener) error {
+	d.mu.Lock()
+	srv := d.httpServer
+	d.mu.Unlock()
+	if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the control API.
+func (d *Daemon) Shutdown(ctx context.Context) error {
+	d.mu.Lock()
+	srv := d.httpServer
+	d.mu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
@@ -0,0 +1,146 @@
+package daemon
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+
+	"github.com/ShreyamKundu/peernet/peer/bandwidth"
+	"github.com/ShreyamKundu/peernet/peer/file"
+)
+
+// ShareRequest asks the daemon to share a file or directory. Empty options use the defaults.
+type ShareRequest struct {
+	Path          string `json:"path"`                     // Absolute path of the file or directory
+	HashAlgorithm string `json:"hash_algorithm,omitempty"` // Empty means file.DefaultHashAlgorithm
+	Chunking      string `json:"chunking,omitempty"`       // Empty means fixed-size chunks
+}
+
+// Limits are upload bandwidth limits in bytes per second; 0 means unlimited.
+// In an update, nil fields are left unchanged.
+type Limits struct {
+	UploadLimit        *int64 `json:"upload_limit,omitempty"`
+	UploadLimitPerPeer *int64 `json:"upload_limit_per_peer,omitempty"`
+}
+
+func (d *Daemon) routes(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /shares", d.listShares)
+	mux.HandleFunc("POST /shares", d.addShare)
+	mux.HandleFunc("GET /shares/{fileHash}/manifest", d.getManifest)
+	mux.HandleFunc("DELETE /shares/{fileHash}", d.removeShare)
+	mux.HandleFunc("GET /limits", d.getLimits)
+	mux.HandleFunc("PUT /limits", d.setLimits)
+	return authorize(token, mux)
+}
+
+// authorize rejects requests without the control token. Only processes that can read
+// the daemon's token file have it, so web pages can't drive the API through the user's
+// browser. Requests with a body must also be JSON, which browsers don't send to another
+// origin without asking it first.
+func authorize(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid control token"})
+			return
+		}
+		if r.Method == http.MethodPost || r.Method == http.MethodPut {
+			if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+				writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "request body must be application/json"})
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (d *Daemon) listShares(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.server.Files())
+}
+
+func (d *Daemon) addShare(w http.ResponseWriter, r *http.Request) {
+	var req ShareRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Path == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "a file path is required"})
+		return
+	}
+	if !filepath.IsAbs(req.Path) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "file path must be absolute"})
+		return
+	}
+
+	alg, err := file.LookupHashAlgorithm(req.HashAlgorithm)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	chunking, err := file.ParseChunking(req.Chunking)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	shared, err := d.Share(req.Path, alg, chunking)
+	if err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, shared)
+}
+
+func (d *Daemon) getManifest(w http.ResponseWriter, r *http.Request) {
+	shared, ok := d.server.File(r.PathValue("fileHash"))
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file is not being shared"})
+		return
+	}
+	writeJSON(w, http.StatusOK, shared.Manifest())
+}
+
+func (d *Daemon) removeShare(w http.ResponseWriter, r *http.Request) {
+	fileHash := r.PathValue("fileHash")
+	if !d.Unshare(fileHash) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "file is not being shared"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unshared"})
+}
+
+func (d *Daemon) getLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, d.currentLimits())
+}
+
+func (d *Daemon) setLimits(w http.ResponseWriter, r *http.Request) {
+	var req Limits
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limits"})
+		return
+	}
+
+	limiter := d.server.UploadLimiter()
+	global, perPeer := limiter.Limits()
+	if req.UploadLimit != nil {
+		global = *req.UploadLimit
+	}
+	if req.UploadLimitPerPeer != nil {
+		perPeer = *req.UploadLimitPerPeer
+	}
+	limiter.SetLimits(global, perPeer)
+	log.Printf("Upload limits changed: %s total, %s per peer", bandwidth.FormatRate(global), bandwidth.FormatRate(perPeer))
+	writeJSON(w, http.StatusOK, d.currentLimits())
+}
+
+func (d *Daemon) currentLimits() Limits {
+	global, perPeer := d.server.UploadLimiter().Limits()
+	return Limits{UploadLimit: &global, UploadLimitPerPeer: &perPeer}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
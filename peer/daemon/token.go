@@ -0,0 +1,43 @@
+package daemon
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CreateToken generates a new control token and writes it to path, readable only by the
+// current user. The daemon creates one each time it starts, and commands read it to
+// authenticate to the control API.
+func CreateToken(path string) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+
+	// Recreate the file rather than overwrite it, so it never keeps wider permissions.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(token); err != nil {
+		f.Close()
+		return "", err
+	}
+	return token, f.Close()
+}
+
+// ReadToken reads the control token the running daemon wrote to path.
+func ReadToken(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("could not read the daemon's control token (is 'peernet serve' running?): %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,232 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// HashCache remembers the hashes of files that have already been chunked, so sharing
+// an unchanged file again doesn't rehash it. Entries are keyed by path and are only
+// reused while the file's size, modification time and inode are unchanged.
+type HashCache struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+// hashCacheVersion changes whenever the way file hashes are computed changes, so
+// hashes computed the old way are discarded rather than reused.
+const hashCacheVersion = 3
+
+// hashCacheFile is the on-disk format of the cache.
+type hashCacheFile struct {
+	Version int                    `json:"version"`
+	Files   map[string]*cacheEntry `json:"files"`
+}
+
+// cacheEntry is the persisted form of one file's hashes.
+type cacheEntry struct {
+	Size        int64    `json:"size"`
+	ModTime     int64    `json:"mtime"` // Nanoseconds since the epoch
+	Inode       uint64   `json:"inode,omitempty"`
+	Algorithm   string   `json:"hash_algorithm"`
+	Chunking    string   `json:"chunking,omitempty"` // Empty means fixed-size chunks
+	FileHash    string   `json:"file_hash"`
+	ChunkHashes []string `json:"chunk_hashes"`
+	ChunkSizes  []int    `json:"chunk_sizes,omitempty"` // Only for variable-size chunks
+}
+
+// fileIdentity is what must match for a cache entry to be reused.
+type fileIdentity struct {
+	size    int64
+	modTime int64
+	inode   uint64
+}
+
+func identify(info os.FileInfo) fileIdentity {
+	return fileIdentity{size: info.Size(), modTime: info.ModTime().UnixNano(), inode: inodeOf(info)}
+}
+
+func (e *cacheEntry) matches(id fileIdentity) bool {
+	return e.Size == id.size && e.ModTime == id.modTime && e.Inode == id.inode
+}
+
+// OpenHashCache loads the cache stored at path. A missing or unreadable cache file
+// starts an empty cache rather than failing, since everything in it can be recomputed.
+func OpenHashCache(path string) *HashCache {
+	c := &HashCache{path: path, entries: make(map[string]*cacheEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read hash cache %s: %v", path, err)
+		}
+		return c
+	}
+	var saved hashCacheFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Warning: ignoring corrupt hash cache %s: %v", path, err)
+		return c
+	}
+	if saved.Version == hashCacheVersion && saved.Files != nil {
+		c.entries = saved.Files
+	}
+	return c
+}
+
+// ChunkFile returns the same result as the package-level ChunkFile, using the cached
+// hashes when the file hasn't changed since it was last chunked the same way.
+func (c *HashCache) ChunkFile(filePath string, alg *HashAlgorithm, chunking Chunking) ([]ChunkInfo, string, error) {
+	chunks, fileHash, updated, err := c.chunkFile(filePath, alg, chunking)
+	if err != nil {
+		return nil, "", err
+	}
+	if updated {
+		c.save()
+	}
+	return chunks, fileHash, nil
+}
+
+// ChunkDirectory chunks every regular file under dir, using the cached hashes of files
+// that haven't changed, and returns the directory's manifest.
+func (c *HashCache) ChunkDirectory(dir string, alg *HashAlgorithm, chunking Chunking) (*Manifest, error) {
+	paths, err := ListDirectory(dir)
+	if err != nil {
+		return nil, err
+	}
+	fileChunks := make([][]ChunkInfo, len(paths))
+	updated := false
+	for i, p := range paths {
+		chunks, _, changed, err := c.chunkFile(filepath.Join(dir, filepath.FromSlash(p)), alg, chunking)
+		if err != nil {
+			return nil, fmt.Errorf("failed to chunk %s: %v", p, err)
+		}
+		fileChunks[i] = chunks
+		updated = updated || changed
+	}
+	// Saved once at the end, since a directory may hold thousands of files.
+	if updated {
+		c.save()
+	}
+	return NewDirectoryManifest(filepath.Base(dir), alg, chunking, paths, fileChunks)
+}
+
+// chunkFile is ChunkFile without saving the cache. It also reports whether the cache
+// was updated.
+func (c *HashCache) chunkFile(filePath string, alg *HashAlgorithm, chunking Chunking) ([]ChunkInfo, string, bool, error) {
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return nil, "", false, err
+	}
+	before, err := os.Stat(absPath)
+	if err != nil {
+		return nil, "", false, err
+	}
+	id := identify(before)
+
+	c.mu.Lock()
+	entry, ok := c.entries[absPath]
+	c.mu.Unlock()
+	if ok && entry.matches(id) && entry.Algorithm == alg.Name && entry.chunking() == chunking {
+		return entry.chunks(), entry.FileHash, false, nil
+	}
+
+	chunks, fileHash, err := ChunkFile(absPath, alg, chunking)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	// Don't cache hashes of a file that was modified while it was being read.
+	after, err := os.Stat(absPath)
+	if err != nil || identify(after) != id {
+		return nil, "", false, fmt.Errorf("file %s changed while it was being chunked", absPath)
+	}
+
+	entry = &cacheEntry{
+		Size:        id.size,
+		ModTime:     id.modTime,
+		Inode:       id.inode,
+		Algorithm:   alg.Name,
+		FileHash:    fileHash,
+		ChunkHashes: make([]string, len(chunks)),
+	}
+	if chunking != FixedChunking {
+		entry.Chunking = string(chunking)
+		entry.ChunkSizes = make([]int, len(chunks))
+	}
+	for i, chunk := range chunks {
+		entry.ChunkHashes[i] = chunk.Hash
+		if entry.ChunkSizes != nil {
+			entry.ChunkSizes[i] = chunk.Size
+		}
+	}
+
+	c.mu.Lock()
+	c.entries[absPath] = entry
+	c.mu.Unlock()
+	return chunks, fileHash, true, nil
+}
+
+// save writes the cache to disk, logging rather than failing, since the cache is
+// only an optimisation.
+func (c *HashCache) save() {
+	c.mu.Lock()
+	err := c.saveLocked()
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("Warning: failed to save hash cache: %v", err)
+	}
+}
+
+// chunking returns how the cached hashes were chunked.
+func (e *cacheEntry) chunking() Chunking {
+	if e.Chunking == "" {
+		return FixedChunking
+	}
+	return Chunking(e.Chunking)
+}
+
+// chunks rebuilds the chunk metadata from a cache entry. Fixed-size chunks are all
+// full-size except possibly the last; variable sizes are stored in the entry.
+func (e *cacheEntry) chunks() []ChunkInfo {
+	chunks := make([]ChunkInfo, len(e.ChunkHashes))
+	for i, hash := range e.ChunkHashes {
+		size := ChunkSize
+		if len(e.ChunkSizes) == len(e.ChunkHashes) {
+			size = e.ChunkSizes[i]
+		} else if i == len(e.ChunkHashes)-1 {
+			size = int(e.Size - int64(i)*ChunkSize)
+		}
+		chunks[i] = ChunkInfo{Index: i, Hash: hash, Size: size}
+	}
+	return chunks
+}
+
+// saveLocked writes the cache to disk, dropping entries for files that no longer exist.
+// c.mu must be held.
+func (c *HashCache) saveLocked() error {
+	if c.path == "" {
+		return nil
+	}
+	for path := range c.entries {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.entries, path)
+		}
+	}
+	data, err := json.Marshal(hashCacheFile{Version: hashCacheVersion, Files: c.entries})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+	tmpPath := c.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, c.path)
+}
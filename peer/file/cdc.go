@@ -0,0 +1,167 @@
+package file
+
+import (
+	"fmt"
+	"io"
+)
+
+// Chunking selects how a file is split into chunks.
+type Chunking string
+
+const (
+	// FixedChunking splits files into ChunkSize-byte chunks. It is the default.
+	FixedChunking Chunking = "fixed"
+	// ContentDefinedChunking places chunk boundaries with FastCDC, so they depend on the
+	// content around them rather than on offsets. Inserting or removing bytes only changes
+	// the chunks near the edit, and identical regions of different files or versions end
+	// up with identical chunk hashes.
+	ContentDefinedChunking Chunking = "fastcdc"
+)
+
+// Bounds of content-defined chunk sizes. Chunks average CDCAverageSize bytes; only the
+// last chunk of a file may be smaller than CDCMinSize.
+const (
+	CDCMinSize     = 256 * 1024
+	CDCAverageSize = 1024 * 1024
+	CDCMaxSize     = 4 * 1024 * 1024
+)
+
+// ParseChunking returns the chunking mode with the given name. An empty name means FixedChunking.
+func ParseChunking(name string) (Chunking, error) {
+	switch Chunking(name) {
+	case "", FixedChunking:
+		return FixedChunking, nil
+	case ContentDefinedChunking:
+		return ContentDefinedChunking, nil
+	}
+	return "", fmt.Errorf("unsupported chunking %q (supported: %s, %s)", name, FixedChunking, ContentDefinedChunking)
+}
+
+// maxChunkSize returns the largest chunk the mode produces.
+func (c Chunking) maxChunkSize() int {
+	if c == ContentDefinedChunking {
+		return CDCMaxSize
+	}
+	return ChunkSize
+}
+
+// maxChunks returns an upper bound on the number of chunks in a file of the given size.
+func (c Chunking) maxChunks(size int64) int {
+	minSize := int64(ChunkSize)
+	if c == ContentDefinedChunking {
+		minSize = CDCMinSize
+	}
+	return int((size + minSize - 1) / minSize)
+}
+
+// FastCDC masks, with normalization level 2: cut points are made harder to find before
+// the average size and easier after it, which keeps chunk sizes close to the average.
+// The mask bits sit at the top of the fingerprint, which covers the last 64 bytes.
+const (
+	cdcMaskSmall = ((1 << 22) - 1) << (64 - 22)
+	cdcMaskLarge = ((1 << 18) - 1) << (64 - 18)
+)
+
+// gearTable maps each byte to a random 64-bit value for the rolling gear hash. It is
+// generated from a fixed seed because every peer must find the same cut points.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x70656572_6e657421) // "peernet!"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// cdcCut returns the length of the next content-defined chunk at the start of data.
+// data must hold at least CDCMaxSize bytes unless it is the end of the file.
+func cdcCut(data []byte) int {
+	n := len(data)
+	if n <= CDCMinSize {
+		return n
+	}
+	n = min(n, CDCMaxSize)
+	normal := min(n, CDCAverageSize)
+
+	var fingerprint uint64
+	i := CDCMinSize
+	for ; i < normal; i++ {
+		fingerprint = (fingerprint << 1) + gearTable[data[i]]
+		if fingerprint&cdcMaskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fingerprint = (fingerprint << 1) + gearTable[data[i]]
+		if fingerprint&cdcMaskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// chunkReader splits a stream into chunks. next copies the next chunk into buffer,
+// which must hold the largest possible chunk, and returns its length. It returns
+// io.EOF once the stream is exhausted.
+type chunkReader interface {
+	next(buffer []byte) (int, error)
+}
+
+// newChunkReader returns the chunkReader for a chunking mode.
+func newChunkReader(r io.Reader, chunking Chunking) chunkReader {
+	if chunking == ContentDefinedChunking {
+		return &cdcReader{r: r, window: make([]byte, 2*CDCMaxSize)}
+	}
+	return &fixedReader{r: r}
+}
+
+// fixedReader cuts a stream into chunks of len(buffer) bytes.
+type fixedReader struct {
+	r io.Reader
+}
+
+func (f *fixedReader) next(buffer []byte) (int, error) {
+	n, err := io.ReadFull(f.r, buffer)
+	if n == 0 {
+		return 0, err
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = nil // A short read is the final chunk; the next call reports io.EOF.
+	}
+	return n, err
+}
+
+// cdcReader cuts a stream at FastCDC boundaries. It reads ahead into window so that a
+// whole maximum-size chunk is always available to search for the cut point.
+type cdcReader struct {
+	r          io.Reader
+	window     []byte
+	start, end int // Unconsumed bytes are window[start:end]
+	eof        bool
+}
+
+func (c *cdcReader) next(buffer []byte) (int, error) {
+	if c.end-c.start < CDCMaxSize && !c.eof {
+		c.end = copy(c.window, c.window[c.start:c.end])
+		c.start = 0
+		n, err := io.ReadFull(c.r, c.window[c.end:])
+		c.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if c.start == c.end {
+		return 0, io.EOF
+	}
+
+	n := copy(buffer, c.window[c.start:c.start+cdcCut(c.window[c.start:c.end])])
+	c.start += n
+	return n, nil
+}
@@ -0,0 +1,113 @@
+package file
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// testData returns n pseudo-random bytes from a fixed xorshift generator, so the
+// content, and with it the cut points, never changes.
+func testData(n int) []byte {
+	data := make([]byte, n)
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range data {
+		state ^= state << 13
+		state ^= state >> 7
+		state ^= state << 17
+		data[i] = byte(state >> 56)
+	}
+	return data
+}
+
+// cdcBoundaries returns the end offset of every content-defined chunk of r.
+func cdcBoundaries(t *testing.T, r io.Reader) []int64 {
+	t.Helper()
+	reader := newChunkReader(r, ContentDefinedChunking)
+	buffer := make([]byte, CDCMaxSize)
+	var boundaries []int64
+	var offset int64
+	for {
+		n, err := reader.next(buffer)
+		if err == io.EOF {
+			return boundaries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		offset += int64(n)
+		boundaries = append(boundaries, offset)
+	}
+}
+
+func TestCDCBoundaries(t *testing.T) {
+	// Chunk IDs depend on these offsets. If they change, peers stop finding each other's
+	// chunks and cached hashes no longer match, so they must stay the same across releases.
+	want := []int64{
+		1316511, 2463359, 3525050, 4170800, 6109392, 7254941, 8804600,
+		10269006, 11507467, 13038622, 14501795, 15725497, 16777216,
+	}
+	data := testData(16 * 1024 * 1024)
+	got := cdcBoundaries(t, bytes.NewReader(data))
+	if !equalOffsets(got, want) {
+		t.Fatalf("chunk boundaries %v, want %v", got, want)
+	}
+
+	// The boundaries don't depend on how the data is read.
+	if halves := cdcBoundaries(t, iotest.HalfReader(bytes.NewReader(data))); !equalOffsets(halves, want) {
+		t.Errorf("chunking short reads gave boundaries %v, want %v", halves, want)
+	}
+}
+
+func TestCDCChunkSizes(t *testing.T) {
+	boundaries := cdcBoundaries(t, bytes.NewReader(testData(16*1024*1024)))
+	var start int64
+	for i, end := range boundaries {
+		size := end - start
+		if size > CDCMaxSize || (size < CDCMinSize && i < len(boundaries)-1) {
+			t.Errorf("chunk %d is %d bytes, outside [%d, %d]", i, size, CDCMinSize, CDCMaxSize)
+		}
+		start = end
+	}
+
+	// Data with no cut points is cut at the maximum size.
+	zeros := cdcBoundaries(t, bytes.NewReader(make([]byte, 2*CDCMaxSize+1)))
+	if want := []int64{CDCMaxSize, 2 * CDCMaxSize, 2*CDCMaxSize + 1}; !equalOffsets(zeros, want) {
+		t.Errorf("chunking zeros gave boundaries %v, want %v", zeros, want)
+	}
+	// A file no larger than the minimum size is one chunk.
+	if small := cdcBoundaries(t, bytes.NewReader(testData(CDCMinSize))); !equalOffsets(small, []int64{CDCMinSize}) {
+		t.Errorf("chunking %d bytes gave boundaries %v", CDCMinSize, small)
+	}
+}
+
+func TestCDCInsertKeepsLaterBoundaries(t *testing.T) {
+	data := testData(16 * 1024 * 1024)
+	before := cdcBoundaries(t, bytes.NewReader(data))
+
+	inserted := []byte("a few bytes inserted at the start of the file")
+	after := cdcBoundaries(t, bytes.NewReader(append(inserted, data...)))
+	shifted := make(map[int64]bool, len(after))
+	for _, end := range after {
+		shifted[end-int64(len(inserted))] = true
+	}
+	// Only the chunk holding the edit may change.
+	for _, end := range before[1:] {
+		if !shifted[end] {
+			t.Errorf("boundary at %d moved after inserting %d bytes at the start", end, len(inserted))
+		}
+	}
+}
+
+func equalOffsets(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -4,8 +4,11 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
 	"os"
+	"runtime"
+	"sync"
 )
 
 // ChunkSize defines the fixed size of each file chunk in bytes.
@@ -15,91 +18,162 @@ const ChunkSize = 1 * 1024 * 1024 // 1 MB
 type ChunkInfo struct {
 	Index int
 	Hash  string
-	Data  []byte // Note: In a real large-file app, you wouldn't hold all data in memory for sharing server.
+	Size  int
 }
 
-// ChunkFile splits a file into chunks and returns their info and the overall file hash.
-// The 'Data' field in ChunkInfo will contain the actual chunk data when returned.
-func ChunkFile(filePath string) ([]ChunkInfo, string, error) {
+// maxHashWorkers caps how many goroutines hash chunks of a single file.
+const maxHashWorkers = 16
+
+// chunkJob is one chunk read from the file, waiting to be hashed.
+type chunkJob struct {
+	index int
+	data  []byte
+}
+
+// ChunkFile reads a file once, splitting it as chunking says and hashing every chunk
+// with alg, and returns the file's identifier: the Merkle root (see MerkleTree) formatted
+// with FormatID. Only metadata is returned; at most a few chunks per hashing goroutine are
+// held in memory at any time, so files of any size can be chunked. Chunks are hashed in
+// parallel across cores.
+func ChunkFile(filePath string, alg *HashAlgorithm, chunking Chunking) ([]ChunkInfo, string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, "", err
 	}
 	defer file.Close()
 
-	// Calculate overall file hash
-	hasher := sha256.New()
-	if _, err := io.Copy(hasher, file); err != nil {
+	stat, err := file.Stat()
+	if err != nil {
 		return nil, "", err
 	}
-	fileHash := hex.EncodeToString(hasher.Sum(nil))
+	maxChunks := chunking.maxChunks(stat.Size())
+	chunks := make([]ChunkInfo, maxChunks)
 
-	// Seek back to the beginning of the file to read chunks
-	file.Seek(0, 0)
+	workers := runtime.NumCPU()
+	if workers > maxHashWorkers {
+		workers = maxHashWorkers
+	}
+	if workers > maxChunks {
+		workers = maxChunks
+	}
+	if workers < 1 {
+		workers = 1
+	}
 
-	var chunks []ChunkInfo
-	buffer := make([]byte, ChunkSize) // Use exported ChunkSize
-	chunkIndex := 0
+	// Buffers are recycled through free, bounding memory to inFlight chunks.
+	inFlight := 2 * workers
+	free := make(chan []byte, inFlight)
+	allocated := 0
+	jobs := make(chan chunkJob, inFlight)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				// Each worker writes only its own index, so no locking is needed.
+				chunks[job.index] = ChunkInfo{
+					Index: job.index,
+					Hash:  CalculateChunkHash(alg, job.data),
+					Size:  len(job.data),
+				}
+				free <- job.data[:cap(job.data)]
+			}
+		}()
+	}
 
-	for {
-		bytesRead, err := file.Read(buffer)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, "", err // Return error if reading fails
+	reader := newChunkReader(file, chunking)
+	var totalChunks int
+	var totalSize int64
+	readErr := func() error {
+		for ; ; totalChunks++ {
+			var buffer []byte
+			if allocated < inFlight {
+				buffer = make([]byte, chunking.maxChunkSize())
+				allocated++
+			} else {
+				buffer = <-free
+			}
+
+			n, err := reader.next(buffer)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if totalChunks >= maxChunks {
+				return fmt.Errorf("file %s grew while it was being chunked", filePath)
+			}
+			totalSize += int64(n)
+			jobs <- chunkJob{index: totalChunks, data: buffer[:n]}
 		}
+	}()
+	close(jobs)
+	wg.Wait()
+	if readErr != nil {
+		return nil, "", readErr
+	}
+	if totalSize != stat.Size() {
+		return nil, "", fmt.Errorf("file %s changed size while it was being chunked", filePath)
+	}
 
-		chunkData := make([]byte, bytesRead) // Create a slice of exact size
-		copy(chunkData, buffer[:bytesRead])
-
-		chunkHasher := sha256.New()
-		chunkHasher.Write(chunkData)
-		chunkHash := hex.EncodeToString(chunkHasher.Sum(nil))
-
-		chunks = append(chunks, ChunkInfo{
-			Index: chunkIndex,
-			Hash:  chunkHash,
-			Data:  chunkData,
-		})
-		chunkIndex++
+	chunks = chunks[:totalChunks]
+	chunkHashes := make([]string, len(chunks))
+	for i := range chunks {
+		chunkHashes[i] = chunks[i].Hash
 	}
 
-	return chunks, fileHash, nil
+	root, err := MerkleRoot(alg, chunkHashes)
+	if err != nil {
+		return nil, "", err
+	}
+	return chunks, alg.FormatID(root), nil
 }
 
 // VerifyChunk compares the hash of data with an expected hash.
-func VerifyChunk(data []byte, expectedHash string) bool {
-	return CalculateChunkHash(data) == expectedHash
+func VerifyChunk(alg *HashAlgorithm, data []byte, expectedHash string) bool {
+	return CalculateChunkHash(alg, data) == expectedHash
 }
 
-// CalculateChunkHash computes the SHA256 hash of a byte slice.
-func CalculateChunkHash(data []byte) string {
-	hasher := sha256.New()
+// CalculateChunkHash computes the hex-encoded hash of a byte slice.
+func CalculateChunkHash(alg *HashAlgorithm, data []byte) string {
+	hasher := alg.New()
 	hasher.Write(data)
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-// WriteChunkAtOffset writes a chunk of data to a file at a specific byte offset.
-// It creates the file if it doesn't exist or truncates it if it's smaller than needed.
-// This function is designed for incremental writing of file chunks.
-func WriteChunkAtOffset(filePath string, data []byte, chunkIndex int) error {
-	// Open the file for reading and writing. Create if not exists, truncate if needed.
-	// os.O_CREATE: Create the file if it does not exist.
-	// os.O_RDWR: Open the file for reading and writing.
-	// os.O_TRUNC: If the file exists and is a regular file, it is truncated to zero size.
-	//             This is important for ensuring the file is the correct size if it was partially downloaded before.
-	//             However, for incremental writing, we need to be careful not to truncate on every write.
-	//             Instead, we open for RDWR and rely on Seek and WriteAt.
-	//             We'll ensure the file size is correct at the start of the download process.
+// ChunkHasher computes a chunk hash incrementally, for data that arrives in pieces.
+type ChunkHasher struct {
+	h hash.Hash
+}
+
+// NewChunkHasher returns a hasher producing the same digest as CalculateChunkHash.
+func NewChunkHasher(alg *HashAlgorithm) *ChunkHasher {
+	return &ChunkHasher{h: alg.New()}
+}
+
+// Write adds data to the hash.
+func (c *ChunkHasher) Write(data []byte) {
+	c.h.Write(data)
+}
+
+// Sum returns the hex-encoded hash of everything written so far.
+func (c *ChunkHasher) Sum() string {
+	return hex.EncodeToString(c.h.Sum(nil))
+}
 
+// WriteChunkAtOffset writes a chunk of data to a file at a specific byte offset.
+// It creates the file if it doesn't exist. This function is designed for incremental
+// writing of file chunks; the file is sized once at the start of the download.
+func WriteChunkAtOffset(filePath string, data []byte, offset int64) error {
 	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to open file %s: %v", filePath, err)
 	}
 	defer file.Close()
 
-	offset := int64(chunkIndex) * ChunkSize
 	n, err := file.WriteAt(data, offset)
 	if err != nil {
 		return fmt.Errorf("failed to write chunk at offset %d: %v", offset, err)
@@ -110,3 +184,42 @@ func WriteChunkAtOffset(filePath string, data []byte, chunkIndex int) error {
 
 	return nil
 }
+
+// ReadChunkAtOffset reads size bytes starting at offset from a file.
+// It is the counterpart of WriteChunkAtOffset and is used to re-verify chunks already on disk.
+func ReadChunkAtOffset(filePath string, offset int64, size int) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %v", filePath, err)
+	}
+	defer file.Close()
+
+	data := make([]byte, size)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read chunk at offset %d: %v", offset, err)
+	}
+	return data[:n], nil
+}
+
+// HashFile computes the identifier of a whole file, as reported by ChunkFile.
+func HashFile(filePath string, alg *HashAlgorithm, chunking Chunking) (string, error) {
+	_, fileHash, err := ChunkFile(filePath, alg, chunking)
+	return fileHash, err
+}
+
+// LegacyFileHash computes the flat SHA-256 of a file's contents, which identified
+// files shared before identifiers became Merkle roots.
+func LegacyFileHash(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
@@ -0,0 +1,269 @@
+package file
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ManifestFile is one file of a shared directory. Every file is chunked on its own, so
+// its data is exactly the chunks FirstChunk to FirstChunk+Chunks-1 of the directory and
+// a chunk never spans two files. Empty files have no chunks.
+type ManifestFile struct {
+	Path       string `json:"path"` // Relative to the directory, with forward slashes
+	Size       int64  `json:"size"`
+	FirstChunk int    `json:"first_chunk"`
+	Chunks     int    `json:"chunks"`
+}
+
+// directoryIDTag starts the data hashed into a directory identifier, so a directory
+// never gets the identifier of a single file with the same chunks.
+const directoryIDTag = "peernet-dir\x00"
+
+// IsDirectory reports whether the manifest describes a directory rather than one file.
+func (m *Manifest) IsDirectory() bool {
+	return len(m.Files) > 0
+}
+
+// ValidRelativePath checks that p is a clean, relative, forward-slash path that stays
+// inside the directory it is relative to, so it can safely be joined to a local path.
+func ValidRelativePath(p string) error {
+	switch {
+	case p == "" || p == ".":
+		return fmt.Errorf("empty path")
+	case strings.ContainsAny(p, "\\\x00"):
+		return fmt.Errorf("path %q contains a backslash or NUL", p)
+	case path.IsAbs(p) || filepath.IsAbs(p) || filepath.VolumeName(p) != "":
+		return fmt.Errorf("path %q is absolute", p)
+	case path.Clean(p) != p:
+		return fmt.Errorf("path %q is not clean", p)
+	case p == ".." || strings.HasPrefix(p, "../"):
+		return fmt.Errorf("path %q leaves the directory", p)
+	}
+	return nil
+}
+
+// ListDirectory returns the regular files under root as sorted relative paths with
+// forward slashes. Symbolic links and special files are skipped.
+func ListDirectory(root string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !entry.Type().IsRegular() {
+			log.Printf("Skipping %s: not a regular file", rel)
+			return nil
+		}
+		if err := ValidRelativePath(rel); err != nil {
+			return fmt.Errorf("cannot share %s: %v", p, err)
+		}
+		paths = append(paths, rel)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// NewDirectoryManifest builds the manifest of a directory from the chunks of each of its
+// files, given in the order of paths. The directory's identifier commits to the Merkle
+// root of all its chunks as well as to every file's path, size and chunk count.
+func NewDirectoryManifest(name string, alg *HashAlgorithm, chunking Chunking, paths []string, fileChunks [][]ChunkInfo) (*Manifest, error) {
+	if len(paths) != len(fileChunks) {
+		return nil, fmt.Errorf("got chunks for %d files but %d paths", len(fileChunks), len(paths))
+	}
+	m := &Manifest{
+		FileName:      name,
+		ChunkSize:     chunking.maxChunkSize(),
+		HashAlgorithm: alg.Name,
+		Files:         make([]ManifestFile, len(paths)),
+		ChunkSizes:    []int{},
+	}
+	if chunking != FixedChunking {
+		m.Chunking = string(chunking)
+	}
+	for i, p := range paths {
+		entry := ManifestFile{Path: p, FirstChunk: len(m.ChunkHashes), Chunks: len(fileChunks[i])}
+		for _, chunk := range fileChunks[i] {
+			m.ChunkHashes = append(m.ChunkHashes, chunk.Hash)
+			m.ChunkSizes = append(m.ChunkSizes, chunk.Size)
+			entry.Size += int64(chunk.Size)
+		}
+		m.FileSize += entry.Size
+		m.Files[i] = entry
+	}
+	if len(m.ChunkHashes) == 0 {
+		return nil, fmt.Errorf("directory %s has no data to share", name)
+	}
+
+	root, err := MerkleRoot(alg, m.ChunkHashes)
+	if err != nil {
+		return nil, err
+	}
+	if m.FileHash, err = DirectoryID(alg, root, m.Files); err != nil {
+		return nil, err
+	}
+	m.computeOffsets()
+	return m, nil
+}
+
+// DirectoryID computes the identifier of a directory from the Merkle root of its chunks
+// and its file list.
+func DirectoryID(alg *HashAlgorithm, chunkRoot string, files []ManifestFile) (string, error) {
+	raw, err := hex.DecodeString(chunkRoot)
+	if err != nil {
+		return "", fmt.Errorf("invalid chunk root: %v", err)
+	}
+	h := alg.New()
+	h.Write([]byte(directoryIDTag))
+	h.Write(raw)
+	for _, f := range files {
+		entry := append([]byte(f.Path), 0)
+		entry = binary.AppendUvarint(entry, uint64(f.Size))
+		entry = binary.AppendUvarint(entry, uint64(f.Chunks))
+		h.Write(entry)
+	}
+	return alg.FormatID(hex.EncodeToString(h.Sum(nil))), nil
+}
+
+// ChunkRoot returns the Merkle root of a directory's chunks, which chunk proofs are
+// checked against, after checking that it and the file list add up to the directory's
+// identifier. Once it has, the manifest's chunk hashes can be trusted as much as the
+// identifier itself.
+func (m *Manifest) ChunkRoot() (string, error) {
+	alg, err := m.Algorithm()
+	if err != nil {
+		return "", err
+	}
+	root, err := MerkleRoot(alg, m.ChunkHashes)
+	if err != nil {
+		return "", err
+	}
+	id, err := DirectoryID(alg, root, m.Files)
+	if err != nil {
+		return "", err
+	}
+	if !SameID(id, m.FileHash) {
+		return "", fmt.Errorf("directory manifest does not match its hash %s", m.FileHash)
+	}
+	return root, nil
+}
+
+// FileOf returns the index in Files of the file containing a chunk, or -1 if the
+// manifest isn't a directory's.
+func (m *Manifest) FileOf(chunkIndex int) int {
+	if !m.IsDirectory() {
+		return -1
+	}
+	// The first file starting after the chunk, less one; empty files are skipped over.
+	i := sort.Search(len(m.Files), func(i int) bool { return m.Files[i].FirstChunk > chunkIndex }) - 1
+	for i > 0 && m.Files[i].Chunks == 0 {
+		i--
+	}
+	return i
+}
+
+// LocateChunk returns the relative path of the file holding a chunk and the chunk's
+// offset within it. For a single file the path is empty and the offset is ChunkOffset.
+func (m *Manifest) LocateChunk(chunkIndex int) (string, int64) {
+	i := m.FileOf(chunkIndex)
+	if i < 0 {
+		return "", m.ChunkOffset(chunkIndex)
+	}
+	f := m.Files[i]
+	return f.Path, m.ChunkOffset(chunkIndex) - m.ChunkOffset(f.FirstChunk)
+}
+
+// validateFiles checks a directory's file list against its chunks: paths are valid,
+// sorted and don't collide, and the files' chunk ranges cover every chunk in order with
+// sizes adding up to each file's size. ChunkSizes must already be validated.
+func (m *Manifest) validateFiles() error {
+	seen := make(map[string]bool, len(m.Files))
+	next := 0
+	for i, f := range m.Files {
+		if err := ValidRelativePath(f.Path); err != nil {
+			return fmt.Errorf("file %d: %v", i, err)
+		}
+		if i > 0 && f.Path <= m.Files[i-1].Path {
+			return fmt.Errorf("files are not sorted by path at %q", f.Path)
+		}
+		seen[f.Path] = true
+		if f.FirstChunk != next || f.Chunks < 0 || f.Chunks > len(m.ChunkSizes)-next {
+			return fmt.Errorf("file %q has an invalid chunk range", f.Path)
+		}
+		var size int64
+		for _, chunkSize := range m.ChunkSizes[next : next+f.Chunks] {
+			size += int64(chunkSize)
+		}
+		if size != f.Size {
+			return fmt.Errorf("chunks of file %q add up to %d bytes, but it has %d", f.Path, size, f.Size)
+		}
+		next += f.Chunks
+	}
+	if next != len(m.ChunkHashes) {
+		return fmt.Errorf("files cover %d chunks, but the manifest lists %d", next, len(m.ChunkHashes))
+	}
+	// A file can't also be a directory holding other files.
+	for _, f := range m.Files {
+		for dir := path.Dir(f.Path); dir != "."; dir = path.Dir(dir) {
+			if seen[dir] {
+				return fmt.Errorf("%q is both a file and a directory", dir)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchFile reports whether a file of a directory is selected by include and exclude
+// patterns, which use path.Match syntax. A pattern without a slash is matched against
+// every element of the file's relative path, so "*.csv" selects CSV files anywhere and
+// "raw" selects everything in any directory called raw. A pattern with a slash is
+// matched against the relative path and each of its parent directories. A file is
+// selected if it matches an include pattern, or there are none, and no exclude pattern.
+func MatchFile(relPath string, include, exclude []string) bool {
+	matches := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			candidates := strings.Split(relPath, "/")
+			if strings.Contains(pattern, "/") {
+				candidates = candidates[:0]
+				for p := relPath; p != "."; p = path.Dir(p) {
+					candidates = append(candidates, p)
+				}
+			}
+			for _, candidate := range candidates {
+				if ok, _ := path.Match(pattern, candidate); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return (len(include) == 0 || matches(include)) && !matches(exclude)
+}
+
+// ValidatePatterns checks include or exclude patterns for syntax errors.
+func ValidatePatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package file
+
+import "testing"
+
+func TestValidRelativePath(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"a.txt", true},
+		{"sub/dir/b.bin", true},
+		{"..a/b", true},
+		{"a/..b", true},
+		{".hidden", true},
+
+		{"", false},
+		{".", false},
+		{"..", false},
+		{"../a", false},
+		{"a/../../b", false},
+		{"a/../b", false},
+		{"a/..", false},
+		{"./a", false},
+		{"/etc/passwd", false},
+		{"/", false},
+		{"a//b", false},
+		{"a/", false},
+		{"a/./b", false},
+		{`..\a`, false},
+		{`a\..\..\b`, false},
+		{`C:\a`, false},
+		{"a\x00b", false},
+	}
+	for _, tt := range tests {
+		err := ValidRelativePath(tt.path)
+		if tt.ok && err != nil {
+			t.Errorf("ValidRelativePath(%q) = %v, want nil", tt.path, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("ValidRelativePath(%q) = nil, want an error", tt.path)
+		}
+	}
+}
+
+func TestMatchFile(t *testing.T) {
+	tests := []struct {
+		path             string
+		include, exclude []string
+		want             bool
+	}{
+		{"a.csv", nil, nil, true},
+		{"data/2024/a.csv", []string{"*.csv"}, nil, true},
+		{"data/2024/a.txt", []string{"*.csv"}, nil, false},
+		{"data/raw/a.csv", []string{"*.csv"}, []string{"raw"}, false},
+		{"data/cooked/a.csv", []string{"*.csv"}, []string{"raw"}, true},
+		{"raw.csv", nil, []string{"raw"}, true},
+		{"images/2024/a.png", []string{"images/2024"}, nil, true},
+		{"images/2024/b/c.png", []string{"images/2024"}, nil, true},
+		{"images/2023/a.png", []string{"images/2024"}, nil, false},
+		{"x/images/2024/a.png", []string{"images/2024"}, nil, false},
+		{"images/2024/a.png", []string{"images/*/a.png"}, nil, true},
+		{"a.txt", []string{"*.csv", "*.txt"}, nil, true},
+		{"a.txt", nil, []string{"*.csv", "*.txt"}, false},
+		{"a.txt", []string{"a.txt"}, []string{"a.txt"}, false},
+		{"sub/a?.txt", []string{"a[?].txt"}, nil, true},
+		{"sub/ab.txt", []string{"a[?].txt"}, nil, false},
+	}
+	for _, tt := range tests {
+		if got := MatchFile(tt.path, tt.include, tt.exclude); got != tt.want {
+			t.Errorf("MatchFile(%q, %q, %q) = %t, want %t", tt.path, tt.include, tt.exclude, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePatterns(t *testing.T) {
+	if err := ValidatePatterns([]string{"*.csv", "images/2024", "a[bc]"}); err != nil {
+		t.Errorf("ValidatePatterns rejected valid patterns: %v", err)
+	}
+	if err := ValidatePatterns([]string{"*.csv", "a[b"}); err == nil {
+		t.Error("ValidatePatterns accepted an unterminated character class")
+	}
+}
+
+func TestValidateFilesRejectsEscapingPaths(t *testing.T) {
+	for _, p := range []string{"../evil", "a/../../evil", "/etc/cron.d/evil", `..\evil`} {
+		m := &Manifest{
+			ChunkHashes: []string{"00"},
+			ChunkSizes:  []int{10},
+			Files:       []ManifestFile{{Path: p, Size: 10, Chunks: 1}},
+		}
+		if err := m.validateFiles(); err == nil {
+			t.Errorf("manifest with file %q passed validation", p)
+		}
+	}
+
+	m := &Manifest{
+		ChunkHashes: []string{"00", "01"},
+		ChunkSizes:  []int{10, 10},
+		Files: []ManifestFile{
+			{Path: "a", Size: 10, Chunks: 1},
+			{Path: "a/b", Size: 10, FirstChunk: 1, Chunks: 1},
+		},
+	}
+	if err := m.validateFiles(); err == nil {
+		t.Error("manifest with a file that is also a directory passed validation")
+	}
+	m.Files[1].Path = "b"
+	if err := m.validateFiles(); err != nil {
+		t.Errorf("valid file list rejected: %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package file
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+
+	"lukechampine.com/blake3"
+)
+
+// HashAlgorithm is a hash function that can be used for chunk hashes and file identifiers.
+type HashAlgorithm struct {
+	Name string // Name used in manifests and configuration, e.g. "sha256"
+	Code uint64 // Multihash code identifying the algorithm in file identifiers
+	Size int    // Digest size in bytes
+	new  func() hash.Hash
+}
+
+// New returns a fresh hash.Hash for the algorithm.
+func (a *HashAlgorithm) New() hash.Hash {
+	return a.new()
+}
+
+// The supported hash algorithms. Codes are from the multicodec table.
+var (
+	SHA256 = &HashAlgorithm{Name: "sha256", Code: 0x12, Size: 32, new: sha256.New}
+	BLAKE3 = &HashAlgorithm{Name: "blake3", Code: 0x1e, Size: 32, new: func() hash.Hash { return blake3.New(32, nil) }}
+	// SHA512_256 is SHA-512 truncated to 256 bits, which is faster than SHA-256 on 64-bit CPUs without SHA extensions.
+	SHA512_256 = &HashAlgorithm{Name: "sha512-256", Code: 0x1015, Size: 32, new: sha512.New512_256}
+)
+
+// DefaultHashAlgorithm is used when sharing a file without choosing an algorithm.
+var DefaultHashAlgorithm = SHA256
+
+var hashAlgorithms = map[string]*HashAlgorithm{
+	SHA256.Name:     SHA256,
+	BLAKE3.Name:     BLAKE3,
+	SHA512_256.Name: SHA512_256,
+}
+
+// LookupHashAlgorithm returns the algorithm with the given name. An empty name means
+// DefaultHashAlgorithm.
+func LookupHashAlgorithm(name string) (*HashAlgorithm, error) {
+	if name == "" {
+		return DefaultHashAlgorithm, nil
+	}
+	alg, ok := hashAlgorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported hash algorithm %q (supported: %v)", name, HashAlgorithmNames())
+	}
+	return alg, nil
+}
+
+// HashAlgorithmNames lists the supported algorithms, sorted by name.
+func HashAlgorithmNames() []string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FormatID turns a hex digest into a self-describing file identifier: the hex encoding
+// of a multihash, which is the algorithm's code and the digest length as varints,
+// followed by the digest.
+func (a *HashAlgorithm) FormatID(digest string) string {
+	prefix := binary.AppendUvarint(nil, a.Code)
+	prefix = binary.AppendUvarint(prefix, uint64(a.Size))
+	return hex.EncodeToString(prefix) + digest
+}
+
+// ParseID splits a file identifier into its algorithm and hex digest. Bare hex digests
+// of SHA-256's size, used before identifiers were self-describing, are SHA-256.
+func ParseID(id string) (*HashAlgorithm, string, error) {
+	raw, err := hex.DecodeString(id)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid file hash %q: %v", id, err)
+	}
+	if len(raw) == SHA256.Size {
+		return SHA256, id, nil
+	}
+
+	code, n := binary.Uvarint(raw)
+	if n <= 0 {
+		return nil, "", fmt.Errorf("invalid file hash %q: bad multihash prefix", id)
+	}
+	size, m := binary.Uvarint(raw[n:])
+	if m <= 0 || uint64(len(raw)-n-m) != size {
+		return nil, "", fmt.Errorf("invalid file hash %q: digest length does not match its prefix", id)
+	}
+	for _, alg := range hashAlgorithms {
+		if alg.Code == code && uint64(alg.Size) == size {
+			return alg, hex.EncodeToString(raw[n+m:]), nil
+		}
+	}
+	return nil, "", fmt.Errorf("file hash %q uses an unsupported hash algorithm (multihash code 0x%x)", id, code)
+}
+
+// SameID reports whether two file identifiers name the same digest under the same
+// algorithm, treating a bare SHA-256 digest and its prefixed form as equal.
+func SameID(a, b string) bool {
+	algA, digestA, errA := ParseID(a)
+	algB, digestB, errB := ParseID(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return algA == algB && digestA == digestB
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package file
+
+import "os"
+
+// inodeOf returns 0 on platforms without inode numbers; size and modification time
+// alone identify the file there.
+func inodeOf(info os.FileInfo) uint64 {
+	return 0
+}
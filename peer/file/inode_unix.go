@@ -0,0 +1,17 @@
+//go:build unix
+
+package file
+
+import (
+	"os"
+	"syscall"
+)
+
+// inodeOf returns the inode number of a file, so a file replaced by another with the
+// same size and modification time is still detected as changed.
+func inodeOf(info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,147 @@
+package file
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LinkScheme is the URI scheme of peernet links.
+const LinkScheme = "peernet"
+
+// Link is a compact reference to a shared file or directory, written as
+//
+//	peernet:<file-hash>?dn=<name>&xl=<size>&tr=<tracker-url>&tr=...
+//
+// after the magnet URI parameters of the same meaning. Only the hash is required.
+type Link struct {
+	FileHash string
+	Name     string
+	Size     int64 // -1 if unknown
+	Trackers []string
+}
+
+// NewLink returns the link to the file a manifest describes, tracked by trackers.
+func NewLink(m *Manifest, trackers []string) *Link {
+	return &Link{FileHash: m.FileHash, Name: m.FileName, Size: m.FileSize, Trackers: trackers}
+}
+
+// String formats the link as a peernet URI.
+func (l *Link) String() string {
+	query := url.Values{}
+	if l.Name != "" {
+		query.Set("dn", l.Name)
+	}
+	if l.Size >= 0 {
+		query.Set("xl", strconv.FormatInt(l.Size, 10))
+	}
+	for _, tracker := range l.Trackers {
+		query.Add("tr", tracker)
+	}
+	uri := LinkScheme + ":" + l.FileHash
+	if len(query) > 0 {
+		uri += "?" + query.Encode()
+	}
+	return uri
+}
+
+// ParseLink parses a peernet URI.
+func ParseLink(s string) (*Link, error) {
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme != LinkScheme || u.Opaque == "" {
+		return nil, fmt.Errorf("invalid link %q: expected %s:<file-hash>?...", s, LinkScheme)
+	}
+	if _, _, err := ParseID(u.Opaque); err != nil {
+		return nil, fmt.Errorf("invalid link: %v", err)
+	}
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid link %q: %v", s, err)
+	}
+	l := &Link{FileHash: u.Opaque, Name: query.Get("dn"), Size: -1, Trackers: query["tr"]}
+	if xl := query.Get("xl"); xl != "" {
+		if l.Size, err = strconv.ParseInt(xl, 10, 64); err != nil || l.Size < 0 {
+			return nil, fmt.Errorf("invalid link %q: bad size %q", s, xl)
+		}
+	}
+	return l, nil
+}
+
+// IsLink reports whether s looks like a peernet URI rather than a hash or a path.
+func IsLink(s string) bool {
+	return strings.HasPrefix(s, LinkScheme+":")
+}
+
+// manifestFileVersion is the version of the manifest file format written by WriteManifest.
+const manifestFileVersion = 1
+
+// manifestDocument is the on-disk format of a manifest file.
+type manifestDocument struct {
+	Version  int       `json:"version"`
+	Trackers []string  `json:"trackers,omitempty"`
+	Manifest *Manifest `json:"manifest"`
+}
+
+// WriteManifest saves a manifest and the trackers its file is announced to, so the file
+// can be downloaded without asking a tracker for its manifest.
+func WriteManifest(path string, m *Manifest, trackers []string) error {
+	data, err := json.MarshalIndent(manifestDocument{Version: manifestFileVersion, Trackers: trackers, Manifest: m}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest file %s: %v", path, err)
+	}
+	return nil
+}
+
+// ReadManifest loads a manifest file written by WriteManifest, checking that the
+// manifest is consistent and matches its file hash.
+func ReadManifest(path string) (*Manifest, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var doc manifestDocument
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse manifest file %s: %v", path, err)
+	}
+	if doc.Version != manifestFileVersion {
+		return nil, nil, fmt.Errorf("manifest file %s has unsupported version %d", path, doc.Version)
+	}
+	if doc.Manifest == nil {
+		return nil, nil, fmt.Errorf("manifest file %s has no manifest", path)
+	}
+	if err := doc.Manifest.Validate(); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest in %s: %v", path, err)
+	}
+	if err := doc.Manifest.VerifyID(); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest in %s: %v", path, err)
+	}
+	return doc.Manifest, doc.Trackers, nil
+}
+
+// VerifyID checks that the manifest's chunk hashes, and a directory's file list, add up
+// to its file hash. Bare SHA-256 hashes of whole files, which identified files before
+// Merkle roots, can't be checked without the file's data and are accepted.
+func (m *Manifest) VerifyID() error {
+	if m.IsDirectory() {
+		_, err := m.ChunkRoot()
+		return err
+	}
+	alg, err := m.Algorithm()
+	if err != nil {
+		return err
+	}
+	root, err := MerkleRoot(alg, m.ChunkHashes)
+	if err != nil {
+		return err
+	}
+	if !SameID(alg.FormatID(root), m.FileHash) && len(m.FileHash) != 2*SHA256.Size {
+		return fmt.Errorf("chunk hashes do not match file hash %s", m.FileHash)
+	}
+	return nil
+}
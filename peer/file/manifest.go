@@ -0,0 +1,167 @@
+package file
+
+import "fmt"
+
+// Manifest describes a file's exact layout: its size, how it is split into chunks and
+// the hash of every chunk in order. It is published to the tracker once per file so
+// downloaders don't have to infer any of it.
+//
+// With fixed chunking every chunk is ChunkSize bytes except possibly the last. With
+// content-defined chunking ChunkSize is the largest a chunk may be and ChunkSizes lists
+// the size of every chunk.
+//
+// A shared directory has one manifest too. Its files are chunked one after the other,
+// ChunkSizes is always listed, and Files says which chunks belong to which file.
+type Manifest struct {
+	FileHash      string   `json:"file_hash"`
+	FileName      string   `json:"file_name"`
+	FileSize      int64    `json:"file_size"`
+	ChunkSize     int      `json:"chunk_size"`
+	Chunking      string   `json:"chunking,omitempty"` // Empty means fixed-size chunks
+	HashAlgorithm string   `json:"hash_algorithm"`
+	ChunkHashes   []string `json:"chunk_hashes"`
+	ChunkSizes    []int    `json:"chunk_sizes,omitempty"` // Only for variable-size chunks and directories
+
+	Files []ManifestFile `json:"files,omitempty"` // Only for directories
+
+	offsets []int64 // Start of each variable-size chunk, filled in by NewManifest and Validate
+}
+
+// NewManifest builds the manifest for a file chunked by ChunkFile with alg and chunking.
+func NewManifest(fileName, fileHash string, alg *HashAlgorithm, chunking Chunking, chunks []ChunkInfo) *Manifest {
+	m := &Manifest{
+		FileHash:      fileHash,
+		FileName:      fileName,
+		ChunkSize:     chunking.maxChunkSize(),
+		HashAlgorithm: alg.Name,
+		ChunkHashes:   make([]string, len(chunks)),
+	}
+	if chunking != FixedChunking {
+		m.Chunking = string(chunking)
+		m.ChunkSizes = make([]int, len(chunks))
+	}
+	for i, chunk := range chunks {
+		m.ChunkHashes[i] = chunk.Hash
+		m.FileSize += int64(chunk.Size)
+		if m.ChunkSizes != nil {
+			m.ChunkSizes[i] = chunk.Size
+		}
+	}
+	m.computeOffsets()
+	return m
+}
+
+// ChunkingMode returns how the file was split into chunks.
+func (m *Manifest) ChunkingMode() (Chunking, error) {
+	return ParseChunking(m.Chunking)
+}
+
+// computeOffsets records where each variable-size chunk starts, so ChunkOffset doesn't
+// have to add up the sizes on every call.
+func (m *Manifest) computeOffsets() {
+	if m.ChunkSizes == nil {
+		m.offsets = nil
+		return
+	}
+	m.offsets = make([]int64, len(m.ChunkSizes))
+	var offset int64
+	for i, size := range m.ChunkSizes {
+		m.offsets[i] = offset
+		offset += int64(size)
+	}
+}
+
+// TotalChunks returns the number of chunks in the file.
+func (m *Manifest) TotalChunks() int {
+	return len(m.ChunkHashes)
+}
+
+// ChunkOffset returns the byte offset of a chunk within the file.
+func (m *Manifest) ChunkOffset(chunkIndex int) int64 {
+	if m.ChunkSizes == nil {
+		return int64(chunkIndex) * int64(m.ChunkSize)
+	}
+	if len(m.offsets) == len(m.ChunkSizes) {
+		return m.offsets[chunkIndex]
+	}
+	var offset int64
+	for _, size := range m.ChunkSizes[:chunkIndex] {
+		offset += int64(size)
+	}
+	return offset
+}
+
+// ChunkLength returns the size of a chunk.
+func (m *Manifest) ChunkLength(chunkIndex int) int {
+	if m.ChunkSizes != nil {
+		return m.ChunkSizes[chunkIndex]
+	}
+	return int(min(int64(m.ChunkSize), m.FileSize-m.ChunkOffset(chunkIndex)))
+}
+
+// Algorithm returns the manifest's hash algorithm.
+func (m *Manifest) Algorithm() (*HashAlgorithm, error) {
+	return LookupHashAlgorithm(m.HashAlgorithm)
+}
+
+// Validate checks that the manifest is internally consistent, uses a supported hash
+// algorithm and, if its file hash is self-describing, that both name the same algorithm.
+func (m *Manifest) Validate() error {
+	alg, err := m.Algorithm()
+	if err != nil || m.HashAlgorithm == "" {
+		return fmt.Errorf("unsupported hash algorithm %q", m.HashAlgorithm)
+	}
+	idAlg, _, err := ParseID(m.FileHash)
+	if err != nil {
+		return err
+	}
+	if idAlg != alg {
+		return fmt.Errorf("file hash uses %s but the manifest uses %s", idAlg.Name, alg.Name)
+	}
+	if m.ChunkSize <= 0 {
+		return fmt.Errorf("invalid chunk size %d", m.ChunkSize)
+	}
+	if m.FileSize < 0 {
+		return fmt.Errorf("invalid file size %d", m.FileSize)
+	}
+	chunking, err := m.ChunkingMode()
+	if err != nil {
+		return err
+	}
+	if m.IsDirectory() {
+		if err := m.validateChunkSizes(); err != nil {
+			return err
+		}
+		return m.validateFiles()
+	}
+	if chunking != FixedChunking {
+		return m.validateChunkSizes()
+	}
+	if m.ChunkSizes != nil {
+		return fmt.Errorf("fixed-size chunks must not list chunk sizes")
+	}
+	if expected := (m.FileSize + int64(m.ChunkSize) - 1) / int64(m.ChunkSize); int64(len(m.ChunkHashes)) != expected {
+		return fmt.Errorf("manifest lists %d chunks, but a %d byte file in %d byte chunks has %d", len(m.ChunkHashes), m.FileSize, m.ChunkSize, expected)
+	}
+	return nil
+}
+
+// validateChunkSizes checks the sizes of variable-size chunks against the file size
+// and records their offsets.
+func (m *Manifest) validateChunkSizes() error {
+	if len(m.ChunkSizes) != len(m.ChunkHashes) {
+		return fmt.Errorf("manifest lists %d chunk hashes but %d chunk sizes", len(m.ChunkHashes), len(m.ChunkSizes))
+	}
+	var total int64
+	for i, size := range m.ChunkSizes {
+		if size <= 0 || size > m.ChunkSize {
+			return fmt.Errorf("chunk %d has invalid size %d", i, size)
+		}
+		total += int64(size)
+	}
+	if total != m.FileSize {
+		return fmt.Errorf("chunk sizes add up to %d bytes, but the file has %d", total, m.FileSize)
+	}
+	m.computeOffsets()
+	return nil
+}
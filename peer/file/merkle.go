@@ -0,0 +1,137 @@
+package file
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// File identifiers are the root of a Merkle tree over the file's chunk hashes, so any
+// chunk can be verified against the identifier alone given its inclusion proof.
+//
+// Leaves are H(0x00 || chunk hash) and inner nodes H(0x01 || left || right), where H is
+// the file's hash algorithm; the prefixes keep a leaf from ever being mistaken for an
+// inner node. When a level has an odd number of nodes, the last one is carried up to the
+// next level unchanged.
+
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// MerkleTree holds every level of the tree, leaves first, so proofs can be produced
+// without rehashing.
+type MerkleTree struct {
+	alg    *HashAlgorithm
+	levels [][][]byte
+}
+
+// NewMerkleTree builds the tree over hex-encoded chunk hashes in chunk order.
+func NewMerkleTree(alg *HashAlgorithm, chunkHashes []string) (*MerkleTree, error) {
+	leaves := make([][]byte, len(chunkHashes))
+	for i, chunkHash := range chunkHashes {
+		raw, err := hex.DecodeString(chunkHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash for chunk %d: %v", i, err)
+		}
+		leaves[i] = hashLeaf(alg, raw)
+	}
+
+	tree := &MerkleTree{alg: alg, levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, hashNode(alg, level[i], level[i+1]))
+			}
+		}
+		tree.levels = append(tree.levels, next)
+		level = next
+	}
+	return tree, nil
+}
+
+// Root returns the hex-encoded root digest. A file with no chunks has the hash of no
+// data as its root.
+func (t *MerkleTree) Root() string {
+	top := t.levels[len(t.levels)-1]
+	if len(top) == 0 {
+		return CalculateChunkHash(t.alg, nil)
+	}
+	return hex.EncodeToString(top[0])
+}
+
+// Proof returns the hex-encoded sibling hashes needed to go from a chunk's leaf to the
+// root, lowest level first. Levels where the node is carried up contribute nothing.
+func (t *MerkleTree) Proof(chunkIndex int) []string {
+	var proof []string
+	index := chunkIndex
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, hex.EncodeToString(level[sibling]))
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// MerkleRoot returns the root digest for a list of hex-encoded chunk hashes.
+func MerkleRoot(alg *HashAlgorithm, chunkHashes []string) (string, error) {
+	tree, err := NewMerkleTree(alg, chunkHashes)
+	if err != nil {
+		return "", err
+	}
+	return tree.Root(), nil
+}
+
+// VerifyMerkleProof reports whether chunkHash is the hash of chunk chunkIndex of a file
+// with totalChunks chunks and the given root digest.
+func VerifyMerkleProof(alg *HashAlgorithm, root string, chunkIndex, totalChunks int, chunkHash string, proof []string) bool {
+	if chunkIndex < 0 || chunkIndex >= totalChunks {
+		return false
+	}
+	raw, err := hex.DecodeString(chunkHash)
+	if err != nil {
+		return false
+	}
+	node := hashLeaf(alg, raw)
+	index, width := chunkIndex, totalChunks
+	for width > 1 {
+		sibling := index ^ 1
+		if sibling < width {
+			if len(proof) == 0 {
+				return false
+			}
+			siblingHash, err := hex.DecodeString(proof[0])
+			if err != nil {
+				return false
+			}
+			proof = proof[1:]
+			if index%2 == 0 {
+				node = hashNode(alg, node, siblingHash)
+			} else {
+				node = hashNode(alg, siblingHash, node)
+			}
+		}
+		index /= 2
+		width = (width + 1) / 2
+	}
+	return len(proof) == 0 && hex.EncodeToString(node) == root
+}
+
+func hashLeaf(alg *HashAlgorithm, chunkHash []byte) []byte {
+	h := alg.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(chunkHash)
+	return h.Sum(nil)
+}
+
+func hashNode(alg *HashAlgorithm, left, right []byte) []byte {
+	h := alg.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
@@ -0,0 +1,116 @@
+package file
+
+import (
+	"fmt"
+	"testing"
+)
+
+// merkleVectors are roots over the chunk hashes of "chunk 0", "chunk 1", ..., computed
+// independently of this package. The tracker checks manifests against the same
+// vectors, so the two implementations can't drift apart.
+var merkleVectors = []struct {
+	alg    *HashAlgorithm
+	chunks int
+	root   string
+}{
+	{SHA256, 1, "9c1720a2c8e01fcf0dd095bddf6cfd846e0b799cd87180031af929106a80524e"},
+	{SHA256, 2, "9d7c13656b747dcb0a00fe91f914e8ccebd46f593030eb3e167736081d3ad880"},
+	{SHA256, 3, "7676aa547be2859cb87140342a70dc1c608365b1f6d09085cf106d005dd59e75"},
+	{SHA256, 5, "7a949bf4611de7ddd9c2f4588f1751b396908350124dd901c0dd55710def786e"},
+	{SHA512_256, 1, "fb8f9b15393c4cd5a2cd78039a3db1e6e8fc84ed6e1ba94447cd21efa4ab12bc"},
+	{SHA512_256, 2, "4627a0b883f2fdc21f01e04d3b9fd041608f29f286f566ba326ea740c2c4ece0"},
+	{SHA512_256, 3, "ea6d48fb2d1870a3d722978d432594756f1d5c5a8bd7f4f39f326ae5c2c2c7dc"},
+	{SHA512_256, 5, "437d3db6f632201e98ebad2d4cdd0927dceed227f6a51d48421b44d73738cf40"},
+}
+
+func testChunkHashes(alg *HashAlgorithm, n int) []string {
+	hashes := make([]string, n)
+	for i := range hashes {
+		hashes[i] = CalculateChunkHash(alg, []byte(fmt.Sprintf("chunk %d", i)))
+	}
+	return hashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	for _, v := range merkleVectors {
+		root, err := MerkleRoot(v.alg, testChunkHashes(v.alg, v.chunks))
+		if err != nil {
+			t.Fatalf("%s, %d chunks: %v", v.alg.Name, v.chunks, err)
+		}
+		if root != v.root {
+			t.Errorf("%s, %d chunks: root %s, want %s", v.alg.Name, v.chunks, root, v.root)
+		}
+	}
+
+	if root, _ := MerkleRoot(SHA256, nil); root != CalculateChunkHash(SHA256, nil) {
+		t.Errorf("root of no chunks is %s, want the hash of no data", root)
+	}
+	if _, err := MerkleRoot(SHA256, []string{"not hex"}); err == nil {
+		t.Error("MerkleRoot accepted a chunk hash that isn't hex")
+	}
+}
+
+func TestMerkleProof(t *testing.T) {
+	for _, v := range merkleVectors {
+		hashes := testChunkHashes(v.alg, v.chunks)
+		tree, err := NewMerkleTree(v.alg, hashes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, chunkHash := range hashes {
+			proof := tree.Proof(i)
+			if !VerifyMerkleProof(v.alg, v.root, i, v.chunks, chunkHash, proof) {
+				t.Errorf("%s, %d chunks: proof of chunk %d rejected", v.alg.Name, v.chunks, i)
+			}
+
+			other := hashes[(i+1)%len(hashes)]
+			if v.chunks > 1 && VerifyMerkleProof(v.alg, v.root, i, v.chunks, other, proof) {
+				t.Errorf("%s, %d chunks: proof of chunk %d accepted another chunk's hash", v.alg.Name, v.chunks, i)
+			}
+			if v.chunks > 1 && VerifyMerkleProof(v.alg, v.root, (i+1)%v.chunks, v.chunks, chunkHash, proof) {
+				t.Errorf("%s, %d chunks: proof of chunk %d accepted at another index", v.alg.Name, v.chunks, i)
+			}
+			if len(proof) > 0 && VerifyMerkleProof(v.alg, v.root, i, v.chunks, chunkHash, proof[:len(proof)-1]) {
+				t.Errorf("%s, %d chunks: truncated proof of chunk %d accepted", v.alg.Name, v.chunks, i)
+			}
+			if VerifyMerkleProof(v.alg, v.root, i, v.chunks, chunkHash, append(proof, v.root)) {
+				t.Errorf("%s, %d chunks: proof of chunk %d accepted with an extra hash", v.alg.Name, v.chunks, i)
+			}
+		}
+		if VerifyMerkleProof(v.alg, v.root, v.chunks, v.chunks, hashes[0], tree.Proof(0)) {
+			t.Errorf("%s, %d chunks: proof accepted for an index past the last chunk", v.alg.Name, v.chunks)
+		}
+	}
+}
+
+func TestMerkleProofCarriesOddNodeUp(t *testing.T) {
+	// With 5 chunks the last one is carried up twice, so its proof is just the root of
+	// the first four.
+	hashes := testChunkHashes(SHA256, 5)
+	tree, err := NewMerkleTree(SHA256, hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstFour, _ := MerkleRoot(SHA256, hashes[:4])
+	if proof := tree.Proof(4); len(proof) != 1 || proof[0] != firstFour {
+		t.Errorf("proof of chunk 4 is %v, want [%s]", proof, firstFour)
+	}
+}
+
+func TestDirectoryID(t *testing.T) {
+	root, err := MerkleRoot(SHA256, testChunkHashes(SHA256, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []ManifestFile{
+		{Path: "a.txt", Size: 100, Chunks: 1},
+		{Path: "sub/b.bin", Size: 300, Chunks: 2},
+	}
+	id, err := DirectoryID(SHA256, root, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "1220509ea86bf6a9a41b476860c84e9c1df8868a0d2b4d3b8a348f6c5e038dc082f9"; id != want {
+		t.Errorf("directory ID %s, want %s", id, want)
+	}
+}
@@ -5,10 +5,13 @@ go 1.24.1
 require (
 	github.com/ShreyamKundu/peernet v0.0.0-20250708180057-fb06273adf84
 	github.com/spf13/cobra v1.9.1
+	golang.org/x/time v0.12.0
 	gopkg.in/yaml.v3 v3.0.1
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
 	golang.org/x/net v0.38.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.26.0 // indirect
@@ -21,3 +24,6 @@ require (
 	github.com/spf13/pflag v1.0.6 // indirect
 	google.golang.org/grpc v1.73.0
 )
+
+// The generated gRPC code lives in the repository root module; build against the local copy.
+replace github.com/ShreyamKundu/peernet => ../
@@ -2,29 +2,48 @@ package p2p
 
 import (
 	"bytes"
-	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"os" // Added for file operations
-	"path/filepath"
+	"net/url"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/ShreyamKundu/peernet/peer/file" // Import the file package for VerifyChunk and WriteChunkAtOffset
-	pb "github.com/ShreyamKundu/peernet/proto"
-
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"github.com/ShreyamKundu/peernet/peer/file"
 )
 
 // TrackerClient communicates with the tracker's REST API.
 type TrackerClient struct {
 	baseURL string
-	token   string
 	client  *http.Client
+
+	mu    sync.Mutex // Guards token and serialises refreshes
+	token string
+	store TokenStore // Nil if expired tokens aren't refreshed
+}
+
+// Tokens are the credentials the tracker issues on registration and login. The access
+// token authenticates requests; the refresh token is exchanged for new tokens when it
+// expires, once only, since the tracker rotates it on every refresh.
+type Tokens struct {
+	PeerID       string `json:"peer_id"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// TokenStore persists a peer's tracker credentials. Refreshed tokens are saved to it and
+// it is read before refreshing, so processes sharing it don't reuse a rotated token.
+// LockTokens holds off other processes between reading the tokens and saving new ones;
+// the unlock function it returns releases them.
+type TokenStore interface {
+	LockTokens() (unlock func(), err error)
+	LoadTokens() (token, refreshToken string, err error)
+	SaveTokens(token, refreshToken string) error
 }
 
 // PeerInfo holds information about a peer that has a chunk.
@@ -32,47 +51,258 @@ type PeerInfo struct {
 	ID              string  `json:"id"`
 	Address         string  `json:"address"`
 	ReputationScore float64 `json:"reputation_score"`
+
+	// Set when the peer holds the chunk as part of a different file with an identical
+	// chunk; requests to it must name that file and chunk index instead.
+	FileHash   string `json:"file_hash,omitempty"`
+	ChunkIndex int    `json:"chunk_index,omitempty"`
+}
+
+// source returns the file hash and chunk index to request from the peer for the given chunk.
+func (p PeerInfo) source(fileHash string, chunkIndex int) (string, int) {
+	if p.FileHash != "" {
+		return p.FileHash, p.ChunkIndex
+	}
+	return fileHash, chunkIndex
 }
 
 // ChunkLookupInfo holds information for a specific chunk, including its hash and available peers.
 type ChunkLookupInfo struct {
 	ChunkHash string     `json:"chunk_hash"`
 	Peers     []PeerInfo `json:"peers"`
+	// Peers the tracker could not reach at their registered address. They are only
+	// tried once every peer in Peers has failed.
+	UnreachablePeers []PeerInfo `json:"unreachable_peers,omitempty"`
 }
 
 // LookupResult is the structure of the response from the /lookup endpoint.
 type LookupResult struct {
-	Chunks map[int]ChunkLookupInfo `json:"chunks"` // Now maps chunk index to ChunkLookupInfo
+	Chunks   map[int]ChunkLookupInfo `json:"chunks"`              // Now maps chunk index to ChunkLookupInfo
+	FileName string                  `json:"file_name,omitempty"` // Name the file was announced with
+	Manifest *file.Manifest          `json:"manifest,omitempty"`  // Nil for files announced before manifests existed
+
+	HashAlgorithm string `json:"hash_algorithm,omitempty"` // Algorithm the file hash and chunk hashes use
 }
 
-// NewTrackerClient creates a new client for the tracker.
-func NewTrackerClient(baseURL, token string) *TrackerClient {
+// HeartbeatResult is the tracker's response to a heartbeat.
+type HeartbeatResult struct {
+	OfflineAfterSeconds int  `json:"offline_after_seconds"` // Silence after which the tracker marks the peer offline
+	WasOffline          bool `json:"was_offline"`           // The peer's announcements may have been purged
+}
+
+// NewTrackerClient creates a new client for the tracker. tlsConfig may be nil, in which
+// case HTTPS trackers are verified against the system roots.
+func NewTrackerClient(baseURL, token string, tlsConfig *tls.Config) *TrackerClient {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if tlsConfig != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		client.Transport = transport
+	}
 	return &TrackerClient{
 		baseURL: baseURL,
 		token:   token,
-		client:  &http.Client{Timeout: 10 * time.Second},
+		client:  client,
 	}
 }
 
-// Announce tells the tracker that this peer has a specific chunk.
-// It now includes the chunkHash for verification by downloaders.
-func (c *TrackerClient) Announce(filePath, fileHash string, totalChunks, chunkIndex int, chunkHash string) error {
+// SetTokenStore makes the client refresh its access token through store when the
+// tracker rejects it.
+func (c *TrackerClient) SetTokenStore(store TokenStore) {
+	c.mu.Lock()
+	c.store = store
+	c.mu.Unlock()
+}
+
+func (c *TrackerClient) accessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+// do sends a request authenticated with the access token. If the tracker rejects the
+// token, it is refreshed and the request sent once more.
+func (c *TrackerClient) do(req *http.Request) (*http.Response, error) {
+	token := c.accessToken()
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := c.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	c.mu.Lock()
+	canRefresh := c.store != nil
+	c.mu.Unlock()
+	if !canRefresh {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if err := c.refresh(token); err != nil {
+		return nil, fmt.Errorf("tracker rejected the access token and it could not be refreshed (run 'peernet login'): %v", err)
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	retry.Header.Set("Authorization", "Bearer "+c.accessToken())
+	return c.client.Do(retry)
+}
+
+// refresh replaces the access token stale with a new one, unless another request or
+// another process sharing the token store already has.
+func (c *TrackerClient) refresh(stale string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.token != stale {
+		return nil
+	}
+	unlock, err := c.store.LockTokens()
+	if err != nil {
+		return fmt.Errorf("failed to lock saved tokens: %v", err)
+	}
+	defer unlock()
+	token, refreshToken, err := c.store.LoadTokens()
+	if err != nil {
+		return fmt.Errorf("failed to load saved tokens: %v", err)
+	}
+	if token != "" && token != stale {
+		c.token = token
+		return nil
+	}
+	if refreshToken == "" {
+		return fmt.Errorf("no refresh token saved")
+	}
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": refreshToken})
+	resp, err := c.client.Post(c.baseURL+"/api/v1/peers/token/refresh", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	tokens, err := decodeTokens(resp, http.StatusOK, "refresh")
+	if err != nil {
+		return err
+	}
+
+	c.token = tokens.Token
+	if err := c.store.SaveTokens(tokens.Token, tokens.RefreshToken); err != nil {
+		log.Printf("Warning: failed to save refreshed tokens; run 'peernet login' if the tracker rejects them later: %v", err)
+	}
+	log.Println("Refreshed tracker access token.")
+	return nil
+}
+
+func decodeTokens(resp *http.Response, wantStatus int, op string) (*Tokens, error) {
+	if resp.StatusCode != wantStatus {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s failed with status: %s, body: %s", op, resp.Status, string(bodyBytes))
+	}
+	var tokens Tokens
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+// Register creates a peer account on the tracker, returning the new peer ID and its tokens.
+func (c *TrackerClient) Register(address, password string) (*Tokens, error) {
+	payload := map[string]string{"address": address, "password": password}
+	body, _ := json.Marshal(payload)
+	resp, err := c.client.Post(c.baseURL+"/api/v1/peers/register", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return decodeTokens(resp, http.StatusCreated, "registration")
+}
+
+// Login gets new tokens for an existing peer account.
+func (c *TrackerClient) Login(peerID, password string) (*Tokens, error) {
+	body, _ := json.Marshal(map[string]string{"peer_id": peerID, "password": password})
+	resp, err := c.client.Post(c.baseURL+"/api/v1/peers/login", "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return decodeTokens(resp, http.StatusOK, "login")
+}
+
+// ChangePassword changes the peer's password. The tracker revokes every refresh token of
+// the peer and returns new tokens, which the client switches to and saves to its store.
+func (c *TrackerClient) ChangePassword(currentPassword, newPassword string) (*Tokens, error) {
+	body, _ := json.Marshal(map[string]string{"current_password": currentPassword, "new_password": newPassword})
+	req, err := http.NewRequest("POST", c.baseURL+"/api/v1/peers/password", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	tokens, err := decodeTokens(resp, http.StatusOK, "password change")
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = tokens.Token
+	if c.store != nil {
+		unlock, err := c.store.LockTokens()
+		if err == nil {
+			err = c.store.SaveTokens(tokens.Token, tokens.RefreshToken)
+			unlock()
+		}
+		if err != nil {
+			return tokens, fmt.Errorf("password changed, but the new tokens could not be saved: %v", err)
+		}
+	}
+	return tokens, nil
+}
+
+// AnnounceFile publishes a file's manifest and announces every one of its chunks in a
+// single request. Announcing the same file again is harmless; a different manifest for
+// an existing file hash is rejected.
+func (c *TrackerClient) AnnounceFile(manifest *file.Manifest) error {
+	return c.announceBatch(map[string]interface{}{
+		"file_hash": manifest.FileHash,
+		"manifest":  manifest,
+	})
+}
+
+// AnnounceChunks announces the chunks of a file this peer holds, where held[i] is set
+// for chunk i, as a bitfield. The file's manifest must already be published, unless it
+// is given to publish with the announcement.
+func (c *TrackerClient) AnnounceChunks(fileHash string, held []bool, manifest *file.Manifest) error {
+	bitfield := make([]byte, (len(held)+7)/8)
+	for i, ok := range held {
+		if ok {
+			bitfield[i/8] |= 0x80 >> (i % 8)
+		}
+	}
 	payload := map[string]interface{}{
-		"file_hash":    fileHash,
-		"file_name":    filepath.Base(filePath),
-		"total_chunks": totalChunks,
-		"chunk_index":  chunkIndex,
-		"chunk_hash":   chunkHash, // Send the chunk hash to the tracker
+		"file_hash": fileHash,
+		"bitfield":  base64.StdEncoding.EncodeToString(bitfield),
 	}
+	if manifest != nil {
+		payload["manifest"] = manifest
+	}
+	return c.announceBatch(payload)
+}
+
+func (c *TrackerClient) announceBatch(payload map[string]interface{}) error {
 	body, _ := json.Marshal(payload)
-	req, err := http.NewRequest("POST", c.baseURL+"/api/v1/files/announce", bytes.NewBuffer(body))
+	req, err := http.NewRequest("POST", c.baseURL+"/api/v1/files/announce/batch", bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.token)
-
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -85,16 +315,71 @@ func (c *TrackerClient) Announce(filePath, fileHash string, totalChunks, chunkIn
 	return nil
 }
 
+// Unannounce withdraws every chunk of a file this peer announced, so the tracker stops
+// handing it out as a source.
+func (c *TrackerClient) Unannounce(fileHash string) error {
+	body, _ := json.Marshal(map[string]string{"file_hash": fileHash})
+	return c.unannounce("/api/v1/files/unannounce", body)
+}
+
+// UnannounceAll withdraws every chunk this peer announced, of every file.
+func (c *TrackerClient) UnannounceAll() error {
+	return c.unannounce("/api/v1/files/unannounce/all", nil)
+}
+
+func (c *TrackerClient) unannounce(path string, body []byte) error {
+	req, err := http.NewRequest("POST", c.baseURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unannounce failed with status: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+	return nil
+}
+
+// Heartbeat tells the tracker this peer is still online.
+func (c *TrackerClient) Heartbeat() (*HeartbeatResult, error) {
+	req, err := http.NewRequest("POST", c.baseURL+"/api/v1/peers/heartbeat", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("heartbeat failed with status: %s, body: %s", resp.Status, string(bodyBytes))
+	}
+
+	var result HeartbeatResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
 // Lookup asks the tracker for peers that have chunks for a given file hash,
 // now including the expected chunk hashes.
 func (c *TrackerClient) Lookup(fileHash string) (*LookupResult, error) {
-	req, err := http.NewRequest("GET", c.baseURL+"/api/v1/files/lookup/"+fileHash, nil)
+	// Tell the tracker which hash algorithms we can verify, so it can refuse up front.
+	query := url.Values{"hash_algorithms": {strings.Join(file.HashAlgorithmNames(), ",")}}
+	req, err := http.NewRequest("GET", c.baseURL+"/api/v1/files/lookup/"+fileHash+"?"+query.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+c.token)
-
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -127,9 +412,7 @@ func (c *TrackerClient) SubmitFeedback(targetPeerID, fileHash string, chunkIndex
 		return
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.token)
-
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		log.Printf("Error submitting feedback: %v", err)
 		return
@@ -140,145 +423,3 @@ func (c *TrackerClient) SubmitFeedback(targetPeerID, fileHash string, chunkIndex
 		log.Printf("Feedback submission failed: %s - %s", resp.Status, string(bodyBytes))
 	}
 }
-
-// Downloader manages the concurrent download of file chunks.
-type Downloader struct {
-	trackerClient *TrackerClient
-}
-
-func NewDownloader(client *TrackerClient) *Downloader {
-	return &Downloader{trackerClient: client}
-}
-
-// DownloadFile coordinates the entire file download process, writing chunks directly to disk.
-func (d *Downloader) DownloadFile(fileHash string, lookupResult *LookupResult, outputPath string) error {
-	totalChunks := len(lookupResult.Chunks)
-	if totalChunks == 0 {
-		return fmt.Errorf("no chunks available for file")
-	}
-
-	// Determine the total expected file size. This assumes all chunks are ChunkSize,
-	// except possibly the last one. For a more robust solution, the tracker should
-	// provide the total file size.
-	// For now, we'll assume total_chunks * ChunkSize for allocation.
-	// This might over-allocate if the last chunk is smaller, but ensures space.
-	expectedFileSize := int64(totalChunks) * file.ChunkSize
-
-	// Create/truncate the output file to its expected size before starting downloads.
-	// This ensures we have enough space and handle partial previous downloads.
-	outputFile, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to create/truncate output file %s: %v", outputPath, err)
-	}
-	// Pre-allocate space if possible (optional, but good for performance on some filesystems)
-	if err := outputFile.Truncate(expectedFileSize); err != nil {
-		log.Printf("Warning: Failed to pre-allocate file size for %s: %v", outputPath, err)
-	}
-	outputFile.Close() // Close immediately as WriteChunkAtOffset will open/close for each write
-
-	// Use a map to track which chunks have been successfully written to disk.
-	// We no longer store the actual byte data here.
-	downloadedChunksStatus := make(map[int]bool)
-	var wg sync.WaitGroup
-	var mu sync.Mutex // Mutex to protect access to downloadedChunksStatus and errs channel
-	errs := make(chan error, totalChunks)
-
-	for i := 0; i < totalChunks; i++ {
-		wg.Add(1)
-		go func(chunkIndex int) {
-			defer wg.Done()
-
-			chunkLookupInfo, ok := lookupResult.Chunks[chunkIndex]
-			if !ok || len(chunkLookupInfo.Peers) == 0 {
-				mu.Lock()
-				errs <- fmt.Errorf("no peers or chunk hash found for chunk %d", chunkIndex)
-				mu.Unlock()
-				return
-			}
-			peers := chunkLookupInfo.Peers
-			expectedChunkHash := chunkLookupInfo.ChunkHash // Get the expected hash for this chunk from tracker response
-
-			// Try peers in order of reputation
-			for _, peer := range peers {
-				log.Printf("Attempting to download chunk %d from peer %s (%s)", chunkIndex, peer.ID, peer.Address)
-				data, err := downloadChunkFromPeer(peer, fileHash, chunkIndex)
-				if err != nil {
-					log.Printf("Failed to download chunk %d from %s: %v. Trying next peer.", chunkIndex, peer.Address, err)
-					d.trackerClient.SubmitFeedback(peer.ID, fileHash, chunkIndex, "FAILED_UPLOAD")
-					continue
-				}
-
-				// Chunk hash verification
-				if !file.VerifyChunk(data, expectedChunkHash) {
-					log.Printf("Downloaded chunk %d from %s failed hash verification. Expected %s, got data with hash %s. Trying next peer.",
-						chunkIndex, peer.Address, expectedChunkHash, file.CalculateChunkHash(data))
-					d.trackerClient.SubmitFeedback(peer.ID, fileHash, chunkIndex, "FAILED_UPLOAD")
-					continue // Try next peer if verification fails
-				}
-
-				// --- IMPORTANT: Write chunk directly to disk here! ---
-				if err := file.WriteChunkAtOffset(outputPath, data, chunkIndex); err != nil {
-					log.Printf("Failed to write chunk %d to disk: %v. Trying next peer.", chunkIndex, err)
-					d.trackerClient.SubmitFeedback(peer.ID, fileHash, chunkIndex, "FAILED_UPLOAD")
-					continue // If disk write fails, try another peer (or report critical error)
-				}
-				// --- END IMPORTANT ---
-
-				mu.Lock()
-				downloadedChunksStatus[chunkIndex] = true // Mark as successfully written
-				mu.Unlock()
-
-				log.Printf("Successfully downloaded, verified, and wrote chunk %d from peer %s", chunkIndex, peer.ID)
-				d.trackerClient.SubmitFeedback(peer.ID, fileHash, chunkIndex, "SUCCESS_UPLOAD")
-				return // Success, exit the loop for this chunk
-			}
-			mu.Lock()
-			errs <- fmt.Errorf("failed to download, verify, and write chunk %d from any peer", chunkIndex)
-			mu.Unlock()
-		}(i)
-	}
-
-	wg.Wait()
-	close(errs)
-
-	// Check for any errors that occurred during concurrent downloads
-	for err := range errs {
-		if err != nil {
-			return err // Return on the first error
-		}
-	}
-
-	// Final check to ensure all chunks were written
-	for i := 0; i < totalChunks; i++ {
-		mu.Lock()
-		written, ok := downloadedChunksStatus[i]
-		mu.Unlock()
-		if !ok || !written {
-			return fmt.Errorf("missing chunk %d after download completion (not written to disk)", i)
-		}
-	}
-
-	return nil // All chunks downloaded, verified, and written
-}
-
-// downloadChunkFromPeer connects to a single peer via gRPC and downloads one chunk.
-func downloadChunkFromPeer(peer PeerInfo, fileHash string, chunkIndex int) ([]byte, error) {
-	// Using WithTransportCredentials(insecure.NewCredentials()) for simplicity in demo.
-	// In production, this should be grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(nil, ""))
-	conn, err := grpc.NewClient(peer.Address, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return nil, fmt.Errorf("did not connect to peer %s (%s): %v", peer.ID, peer.Address, err)
-	}
-	defer conn.Close()
-
-	c := pb.NewPeerServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	r, err := c.DownloadChunk(ctx, &pb.ChunkRequest{FileHash: fileHash, ChunkIndex: int32(chunkIndex)})
-	if err != nil {
-		return nil, fmt.Errorf("could not download chunk %d from peer %s: %v", chunkIndex, peer.ID, err)
-	}
-
-	return r.GetChunkData(), nil
-}
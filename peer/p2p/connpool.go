@@ -0,0 +1,147 @@
+package p2p
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+const (
+	// DefaultIdleTimeout is how long an unused peer connection is kept open.
+	DefaultIdleTimeout = 2 * time.Minute
+	// reapInterval is how often idle and broken connections are looked for.
+	reapInterval = 30 * time.Second
+)
+
+// ConnManager keeps one multiplexed gRPC connection per peer address so that chunk
+// fetches reuse it instead of dialing for every request. Connections that fail their
+// health check are replaced on next use, and connections left unused for longer than
+// the idle timeout are closed in the background.
+type ConnManager struct {
+	idleTimeout time.Duration
+	dialOpts    []grpc.DialOption
+
+	mu    sync.Mutex
+	conns map[string]*pooledConn // keyed by peer ID and address
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type pooledConn struct {
+	conn     *grpc.ClientConn
+	inUse    int       // Number of callers currently holding the connection
+	lastUsed time.Time // When the connection was last released
+}
+
+// NewConnManager creates a connection manager and starts its idle reaper.
+// dialOpts are applied to every connection it creates.
+func NewConnManager(idleTimeout time.Duration, dialOpts ...grpc.DialOption) *ConnManager {
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultIdleTimeout
+	}
+	m := &ConnManager{
+		idleTimeout: idleTimeout,
+		dialOpts:    dialOpts,
+		conns:       make(map[string]*pooledConn),
+		done:        make(chan struct{}),
+	}
+	go m.reap()
+	return m
+}
+
+// Get returns a healthy connection to a peer, dialing one if needed. The peer ID is
+// used as the connection's authority, so under TLS the remote certificate must have
+// been issued for that peer ID.
+// The caller must call the returned release function when its request is finished.
+func (m *ConnManager) Get(peer PeerInfo) (*grpc.ClientConn, func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := peer.ID + "@" + peer.Address
+	pc, ok := m.conns[key]
+	if ok && !healthy(pc.conn) {
+		log.Printf("Connection to %s is %s, reconnecting.", peer.Address, pc.conn.GetState())
+		// Other callers may still be using it; they will see their RPC fail and retry.
+		pc.conn.Close()
+		delete(m.conns, key)
+		ok = false
+	}
+	if !ok {
+		opts := append([]grpc.DialOption{grpc.WithAuthority(peer.ID)}, m.dialOpts...)
+		conn, err := grpc.NewClient(peer.Address, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("did not connect to %s: %v", peer.Address, err)
+		}
+		pc = &pooledConn{conn: conn}
+		m.conns[key] = pc
+	}
+
+	pc.inUse++
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			m.mu.Lock()
+			pc.inUse--
+			pc.lastUsed = time.Now()
+			m.mu.Unlock()
+		})
+	}
+	return pc.conn, release, nil
+}
+
+// Close closes every pooled connection and stops the reaper.
+func (m *ConnManager) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		for key, pc := range m.conns {
+			pc.conn.Close()
+			delete(m.conns, key)
+		}
+	})
+}
+
+// reap periodically closes connections that are idle or broken.
+func (m *ConnManager) reap() {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+			m.evict()
+		}
+	}
+}
+
+func (m *ConnManager) evict() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for key, pc := range m.conns {
+		if pc.inUse > 0 {
+			continue
+		}
+		if time.Since(pc.lastUsed) >= m.idleTimeout || !healthy(pc.conn) {
+			pc.conn.Close()
+			delete(m.conns, key)
+		}
+	}
+}
+
+// healthy reports whether a connection can still be used. A connection in
+// TRANSIENT_FAILURE is backing off after a failed dial, so a fresh one is preferred.
+func healthy(conn *grpc.ClientConn) bool {
+	switch conn.GetState() {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		return false
+	default:
+		return true
+	}
+}
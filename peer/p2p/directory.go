@@ -0,0 +1,145 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ShreyamKundu/peernet/peer/file"
+)
+
+// chunkTarget returns the file a chunk is written to and its offset within it.
+// A file is assembled at outputPath itself; a directory is assembled under it.
+func chunkTarget(manifest *file.Manifest, outputPath string, chunkIndex int) (string, int64) {
+	relPath, offset := manifest.LocateChunk(chunkIndex)
+	if relPath == "" {
+		return outputPath, offset
+	}
+	return filepath.Join(outputPath, filepath.FromSlash(relPath)), offset
+}
+
+// ErrNoFilesSelected is returned when the include and exclude patterns leave none of
+// a directory's files to download.
+var ErrNoFilesSelected = errors.New("no files of the directory match the include and exclude patterns")
+
+// selectFiles returns the indices in manifest.Files of the files chosen by the include
+// and exclude patterns, and the chunks they are made of.
+func selectFiles(manifest *file.Manifest, include, exclude []string) ([]int, []int) {
+	var files, chunks []int
+	for i, f := range manifest.Files {
+		if !file.MatchFile(f.Path, include, exclude) {
+			continue
+		}
+		files = append(files, i)
+		for c := f.FirstChunk; c < f.FirstChunk+f.Chunks; c++ {
+			chunks = append(chunks, c)
+		}
+	}
+	return files, chunks
+}
+
+// prepareDirectory creates the selected files of a directory under dir at their final
+// sizes, keeping any data from a previous attempt.
+func prepareDirectory(dir string, manifest *file.Manifest, selected []int) error {
+	for _, i := range selected {
+		f := manifest.Files[i]
+		path := filepath.Join(dir, filepath.FromSlash(f.Path))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %v", f.Path, err)
+		}
+		out, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to create output file %s: %v", path, err)
+		}
+		if err := out.Truncate(f.Size); err != nil {
+			log.Printf("Warning: Failed to pre-allocate file size for %s: %v", path, err)
+		}
+		out.Close()
+	}
+	return nil
+}
+
+// finalizeDirectory verifies every selected file of a downloaded directory against the
+// manifest, whose chunk hashes were checked against the directory hash when the download
+// started, then renames the directory to its real name next to it without replacing an
+// existing one. Files left over from an earlier attempt with a different selection are
+// removed first. A directory that fails verification is renamed to <hash>.failed.
+func finalizeDirectory(partial string, manifest *file.Manifest, alg *file.HashAlgorithm, chunking file.Chunking, selected []int) (string, error) {
+	keep := make(map[string]bool, len(selected))
+	for _, i := range selected {
+		f := manifest.Files[i]
+		path := filepath.Join(partial, filepath.FromSlash(f.Path))
+		keep[path] = true
+
+		chunks, _, err := file.ChunkFile(path, alg, chunking)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash downloaded file %s: %v", f.Path, err)
+		}
+		verified := len(chunks) == f.Chunks
+		for c := 0; verified && c < len(chunks); c++ {
+			verified = chunks[c].Hash == manifest.ChunkHashes[f.FirstChunk+c]
+		}
+		if !verified {
+			failed := strings.TrimSuffix(partial, ".download") + failedSuffix
+			if err := os.Rename(partial, failed); err != nil {
+				log.Printf("Warning: failed to mark %s as failed: %v", partial, err)
+				failed = partial
+			}
+			return "", fmt.Errorf("%w: %s does not match the manifest, kept as %s", ErrFileHashMismatch, f.Path, failed)
+		}
+	}
+
+	err := filepath.WalkDir(partial, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || keep[path] {
+			return err
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to clean up %s: %v", partial, err)
+	}
+
+	name := safeFileName(manifest.FileName)
+	if name == "" {
+		name = manifest.FileHash
+	}
+	return renameDirNoClobber(partial, filepath.Dir(partial), name)
+}
+
+// renameDirNoClobber moves the directory src to dir/name, or to "name (1)", "name (2)"
+// and so on if that is taken. Each candidate is reserved by creating it, which fails
+// atomically if it exists, and the contents of src are then moved into it.
+func renameDirNoClobber(src, dir, name string) (string, error) {
+	for i := 0; i < 1000; i++ {
+		candidate := filepath.Join(dir, name)
+		if i > 0 {
+			candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)", name, i))
+		}
+		err := os.Mkdir(candidate, 0755)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s: %v", candidate, err)
+		}
+
+		entries, err := os.ReadDir(src)
+		if err != nil {
+			return "", err
+		}
+		for _, entry := range entries {
+			if err := os.Rename(filepath.Join(src, entry.Name()), filepath.Join(candidate, entry.Name())); err != nil {
+				return "", fmt.Errorf("failed to move %s to %s: %v", entry.Name(), candidate, err)
+			}
+		}
+		if err := os.Remove(src); err != nil {
+			log.Printf("Warning: failed to remove %s: %v", src, err)
+		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("no free directory name for %s in %s", name, dir)
+}
@@ -0,0 +1,763 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ShreyamKundu/peernet/peer/bandwidth"
+	"github.com/ShreyamKundu/peernet/peer/file" // Import the file package for VerifyChunk and WriteChunkAtOffset
+	"github.com/ShreyamKundu/peernet/peer/store"
+	pb "github.com/ShreyamKundu/peernet/proto"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultWorkers is the number of chunks fetched at once when no limit is configured.
+	DefaultWorkers = 8
+	// DefaultPerPeerLimit is the number of concurrent requests sent to a single peer when no limit is configured.
+	DefaultPerPeerLimit = 4
+
+	// blockTimeout is how long a transfer may go without receiving data before it is abandoned.
+	blockTimeout = 15 * time.Second
+	// maxChunkSize bounds the chunk size a peer may announce in a stream, so a misbehaving
+	// peer cannot make us allocate arbitrary amounts of memory.
+	maxChunkSize = 64 * 1024 * 1024
+	// progressAnnounceInterval is how often a download announces newly stored chunks.
+	progressAnnounceInterval = 30 * time.Second
+)
+
+// DownloadOptions controls how much concurrency a Downloader uses.
+type DownloadOptions struct {
+	Workers      int        // Maximum number of chunks being fetched at the same time
+	PerPeerLimit int        // Maximum number of in-flight chunk requests to any single peer
+	Order        ChunkOrder // Order in which missing chunks are requested; nil means rarest-first
+
+	// Download bandwidth limits in bytes per second; 0 means unlimited.
+	RateLimit        int64 // Shared by all peers
+	PerPeerRateLimit int64 // Applied to each peer separately
+
+	// Credentials secures connections to peers; nil uses plaintext.
+	Credentials credentials.TransportCredentials
+
+	// Store receives every verified chunk, and chunks already in it are not downloaded
+	// again. Nil disables the chunk store.
+	Store *store.Store
+
+	// AnnounceProgress announces the chunks of the download kept in Store to the tracker
+	// while it runs, so other peers can fetch them from this peer before it finishes.
+	// Only set it when this peer's daemon is running, since it serves them from the store.
+	AnnounceProgress bool
+
+	// Include and Exclude select which files of a shared directory are downloaded, as
+	// described by file.MatchFile. They have no effect on single files.
+	Include []string
+	Exclude []string
+}
+
+// Downloader manages the concurrent download of file chunks.
+type Downloader struct {
+	trackerClient *TrackerClient
+	conns         *ConnManager // Shared by every chunk fetch, one connection per peer
+	workers       int
+	perPeerLimit  int
+	order         ChunkOrder
+	downloads     *bandwidth.Limiter // Throttles incoming chunk data
+	store         *store.Store
+	announce      bool
+	include       []string
+	exclude       []string
+}
+
+// NewDownloader creates a downloader. Zero or negative options fall back to the defaults.
+// Call Close when finished to release its peer connections.
+func NewDownloader(client *TrackerClient, opts DownloadOptions) *Downloader {
+	creds := opts.Credentials
+	if creds == nil {
+		creds = insecure.NewCredentials()
+	}
+	d := &Downloader{
+		trackerClient: client,
+		conns:         NewConnManager(DefaultIdleTimeout, grpc.WithTransportCredentials(creds)),
+		workers:       opts.Workers,
+		perPeerLimit:  opts.PerPeerLimit,
+		order:         opts.Order,
+		downloads:     bandwidth.NewLimiter(opts.RateLimit, opts.PerPeerRateLimit),
+		store:         opts.Store,
+		announce:      opts.AnnounceProgress,
+		include:       opts.Include,
+		exclude:       opts.Exclude,
+	}
+	if d.workers <= 0 {
+		d.workers = DefaultWorkers
+	}
+	if d.perPeerLimit <= 0 {
+		d.perPeerLimit = DefaultPerPeerLimit
+	}
+	if d.order == nil {
+		d.order = RarestFirstOrder{}
+	}
+	return d
+}
+
+// Close releases the downloader's pooled peer connections.
+func (d *Downloader) Close() {
+	d.conns.Close()
+}
+
+// DownloadFile coordinates the entire file download process, writing chunks directly to disk.
+// Chunks are fetched by a fixed pool of workers in the order chosen by the download's
+// ChunkOrder, and each chunk goes to the least busy peer holding it, subject to the per-peer limit.
+// The file is assembled as <hash>.download in outputDir, with progress recorded in a sidecar
+// state file, so calling DownloadFile again after an interruption re-verifies what is on disk
+// and only fetches missing chunks. Once complete, the whole file is checked against fileHash
+// and renamed to its original name, which is returned.
+//
+// A shared directory is assembled the same way in a <hash>.download directory, fetching
+// only the files selected by the include and exclude patterns, and renamed to the shared
+// directory's name once every selected file is verified.
+func (d *Downloader) DownloadFile(fileHash string, lookupResult *LookupResult, outputDir string) (string, error) {
+	alg, root, err := file.ParseID(fileHash)
+	if err != nil {
+		return "", err
+	}
+	manifest, exactSize, err := downloadManifest(fileHash, alg, lookupResult)
+	if err != nil {
+		return "", err
+	}
+	totalChunks := manifest.TotalChunks()
+	if totalChunks == 0 {
+		return "", fmt.Errorf("no chunks available for file")
+	}
+	outputPath := partialPath(outputDir, fileHash)
+
+	// A directory's identifier covers its file list and the Merkle root of its chunks,
+	// which is what chunk proofs lead to.
+	if manifest.IsDirectory() {
+		if root, err = manifest.ChunkRoot(); err != nil {
+			return "", err
+		}
+	}
+
+	// Chunks are verified against the file hash with the Merkle proof each peer sends.
+	// The manifest's chunk hashes only stand in for a proof once they are known to add up
+	// to the file hash; a manifest claiming otherwise is rejected, and hashes inferred from
+	// the lookup are not trusted. Files identified by a flat hash, from before Merkle roots,
+	// can only be verified against the chunk hashes the tracker supplied; the whole-file
+	// check at the end still catches a tracker that lied about them.
+	manifestTrusted := len(fileHash) == 2*file.SHA256.Size
+	if manifestRoot, err := file.MerkleRoot(alg, manifest.ChunkHashes); err == nil && manifestRoot == root {
+		manifestTrusted = true
+	} else if !manifestTrusted && exactSize {
+		return "", fmt.Errorf("invalid manifest for file %s: its chunk hashes do not add up to the file hash", fileHash)
+	}
+	if !manifestTrusted {
+		log.Printf("The tracker's chunk hashes for file %s are unverified; chunks will only be accepted with a valid Merkle proof.", fileHash)
+	}
+
+	var selectedFiles []int
+	wanted := make([]int, totalChunks)
+	for i := range wanted {
+		wanted[i] = i
+	}
+	if manifest.IsDirectory() {
+		selectedFiles, wanted = selectFiles(manifest, d.include, d.exclude)
+		if len(selectedFiles) == 0 {
+			return "", ErrNoFilesSelected
+		}
+		log.Printf("Downloading %d of %d files in directory %s.", len(selectedFiles), len(manifest.Files), manifest.FileName)
+		if err := prepareDirectory(outputPath, manifest, selectedFiles); err != nil {
+			return "", err
+		}
+	} else {
+		if len(d.include) > 0 || len(d.exclude) > 0 {
+			log.Printf("Warning: include and exclude patterns only apply to directories; downloading the whole file.")
+		}
+		// Create the output file if needed, keeping any data from a previous attempt.
+		outputFile, err := os.OpenFile(outputPath, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return "", fmt.Errorf("failed to create output file %s: %v", outputPath, err)
+		}
+		// Size the file up front. Without a manifest this is only an upper bound, which is
+		// trimmed once the last chunk's real length is known.
+		if err := outputFile.Truncate(manifest.FileSize); err != nil {
+			log.Printf("Warning: Failed to pre-allocate file size for %s: %v", outputPath, err)
+		}
+		outputFile.Close() // Close immediately as WriteChunkAtOffset will open/close for each write
+	}
+
+	// Load the progress of any previous attempt and re-verify it against the data on disk.
+	state := loadDownloadState(outputPath, fileHash)
+	if kept := state.reconcile(manifest, alg, outputPath); kept > 0 {
+		log.Printf("Resuming download: %d of %d chunks already verified on disk.", kept, totalChunks)
+	}
+	if err := state.save(); err != nil {
+		return "", err
+	}
+
+	var missing []int
+	for _, i := range wanted {
+		if !state.isCompleted(i) {
+			missing = append(missing, i)
+		}
+	}
+
+	dl := &download{
+		fileHash:     fileHash,
+		alg:          alg,
+		root:         root,
+		manifest:     manifest,
+		trusted:      manifestTrusted,
+		lookupResult: lookupResult,
+		outputPath:   outputPath,
+		wanted:       wanted,
+		state:        state,
+		load:         newPeerLoad(d.perPeerLimit),
+		race:         newChunkRace(),
+	}
+	if d.store != nil {
+		// Reference the chunks before storing any, so a concurrent GC leaves them alone.
+		if err := d.store.AddRef(fileHash, dl.chunkIDs()); err != nil {
+			log.Printf("Warning: chunks of this download will not be kept in the chunk store: %v", err)
+		} else {
+			dl.store = d.store
+			missing = d.copyStoredChunks(dl, missing)
+		}
+	}
+	for _, chunkIndex := range missing {
+		dl.race.add(chunkIndex)
+	}
+
+	// Peers can only fetch stored chunks from us without a proof, so they must be able
+	// to check them against a manifest the tracker publishes.
+	stopAnnouncing := make(chan struct{})
+	announceDone := make(chan struct{})
+	if d.announce && dl.store != nil && dl.trusted && exactSize {
+		go func() {
+			defer close(announceDone)
+			d.announceProgress(dl, stopAnnouncing)
+		}()
+	} else {
+		close(announceDone)
+	}
+
+	jobs := make(chan int)
+	errs := make(chan error, totalChunks)
+	var wg sync.WaitGroup
+
+	for w := 0; w < d.workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for chunkIndex := range jobs {
+				if err := d.downloadChunk(dl, chunkIndex); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+
+	log.Printf("Fetching %d chunks in %s order.", len(missing), d.order.Name())
+	for _, chunkIndex := range d.order.Order(missing, lookupResult) {
+		jobs <- chunkIndex
+	}
+	close(jobs)
+
+	// Every chunk has been handed to a worker, so the tail of the download may now
+	// stall on slow peers. Endgame mode races the last few chunks across peers.
+	stopEndgame := make(chan struct{})
+	endgameDone := make(chan struct{})
+	go func() {
+		defer close(endgameDone)
+		d.endgame(dl, stopEndgame)
+	}()
+
+	wg.Wait()
+	close(stopEndgame)
+	<-endgameDone
+	dl.race.cancelAll()
+	dl.racers.Wait()
+	close(errs)
+	close(stopAnnouncing)
+	<-announceDone
+
+	// Persist whatever was verified, even if some chunks failed, so a retry can pick up from here.
+	if err := state.save(); err != nil {
+		log.Printf("Warning: failed to save download state: %v", err)
+	}
+
+	// Check for any errors that occurred during concurrent downloads
+	for err := range errs {
+		if err != nil {
+			return "", err // Return on the first error
+		}
+	}
+
+	// Final check to ensure all chunks were written
+	for _, i := range wanted {
+		if !state.isCompleted(i) {
+			return "", fmt.Errorf("missing chunk %d after download completion (not written to disk)", i)
+		}
+	}
+
+	if !exactSize {
+		lastChunk := totalChunks - 1
+		if err := os.Truncate(outputPath, manifest.ChunkOffset(lastChunk)+int64(state.completedSize(lastChunk))); err != nil {
+			return "", fmt.Errorf("failed to trim %s to its final size: %v", outputPath, err)
+		}
+	}
+
+	// Every chunk is on disk, so the progress file is no longer needed. Even if the
+	// whole-file check below fails, retrying would fetch the same chunks again.
+	if err := state.remove(); err != nil {
+		log.Printf("Warning: failed to remove download state: %v", err)
+	}
+
+	chunking, err := manifest.ChunkingMode()
+	if err != nil {
+		return "", err
+	}
+	var finalPath string
+	if manifest.IsDirectory() {
+		finalPath, err = finalizeDirectory(outputPath, manifest, alg, chunking, selectedFiles)
+	} else {
+		fileName := manifest.FileName
+		if fileName == "" {
+			fileName = lookupResult.FileName
+		}
+		finalPath, err = finalizeDownload(outputPath, fileHash, alg, chunking, fileName)
+	}
+	if err != nil {
+		return "", err
+	}
+	// The chunk store keeps the chunks until the downloaded file is deleted.
+	if dl.store != nil {
+		if err := dl.store.SetOutput(fileHash, finalPath); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	return finalPath, nil
+}
+
+// downloadManifest returns the manifest to download fileHash with, and whether it gives
+// the exact file size. Files announced without a manifest get one inferred from the lookup,
+// assuming the default chunk size; its FileSize is only an upper bound.
+func downloadManifest(fileHash string, alg *file.HashAlgorithm, lookupResult *LookupResult) (*file.Manifest, bool, error) {
+	if m := lookupResult.Manifest; m != nil {
+		if m.FileHash != fileHash {
+			return nil, false, fmt.Errorf("tracker returned the manifest of file %s instead of %s", m.FileHash, fileHash)
+		}
+		if err := m.Validate(); err != nil {
+			return nil, false, fmt.Errorf("invalid manifest for file %s: %v", fileHash, err)
+		}
+		return m, true, nil
+	}
+
+	totalChunks := len(lookupResult.Chunks)
+	m := &file.Manifest{
+		FileHash:      fileHash,
+		FileSize:      int64(totalChunks) * file.ChunkSize,
+		ChunkSize:     file.ChunkSize,
+		HashAlgorithm: alg.Name,
+		ChunkHashes:   make([]string, totalChunks),
+	}
+	for index, info := range lookupResult.Chunks {
+		if index < 0 || index >= totalChunks {
+			return nil, false, fmt.Errorf("tracker returned chunk %d of a %d chunk file", index, totalChunks)
+		}
+		m.ChunkHashes[index] = info.ChunkHash
+	}
+	return m, false, nil
+}
+
+// download holds the state shared by everything working on one DownloadFile call.
+type download struct {
+	fileHash     string
+	alg          *file.HashAlgorithm // Algorithm named by fileHash
+	root         string              // Merkle root chunk proofs lead to: the digest part of fileHash, except for directories
+	manifest     *file.Manifest      // Source of the expected chunk hashes and offsets
+	trusted      bool                // Whether the manifest's chunk hashes are known to match fileHash
+	lookupResult *LookupResult
+	outputPath   string
+	wanted       []int // Chunks being downloaded: all of them, except for a partial directory
+	state        *downloadState
+	load         *peerLoad
+	race         *chunkRace
+	racers       sync.WaitGroup // Endgame requests still running
+	store        *store.Store   // Nil unless verified chunks are kept in the chunk store
+}
+
+// chunkID returns the ID of a chunk, which names it in the chunk store and lets peers
+// that don't share this file serve it anyway. It is empty if the chunk's hash is unknown.
+func (dl *download) chunkID(chunkIndex int) string {
+	hash := dl.manifest.ChunkHashes[chunkIndex]
+	if hash == "" {
+		return ""
+	}
+	return dl.alg.FormatID(hash)
+}
+
+// chunkIDs returns the IDs of every chunk being downloaded with a known hash.
+func (dl *download) chunkIDs() []string {
+	ids := make([]string, 0, len(dl.wanted))
+	for _, i := range dl.wanted {
+		if id := dl.chunkID(i); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// writeChunk writes a verified chunk to its place in the output file or directory.
+func (dl *download) writeChunk(chunkIndex int, data []byte) error {
+	path, offset := chunkTarget(dl.manifest, dl.outputPath, chunkIndex)
+	return file.WriteChunkAtOffset(path, data, offset)
+}
+
+// copyStoredChunks writes the missing chunks that are already in the chunk store,
+// whichever file they were stored for, and returns the chunks still to be downloaded.
+func (d *Downloader) copyStoredChunks(dl *download, missing []int) []int {
+	var remaining []int
+	copied := 0
+	for _, chunkIndex := range missing {
+		id := dl.chunkID(chunkIndex)
+		if id == "" {
+			remaining = append(remaining, chunkIndex)
+			continue
+		}
+		data, err := dl.store.Read(id)
+		if err != nil || !verifyChunk(dl, chunkIndex, data, nil) {
+			remaining = append(remaining, chunkIndex)
+			continue
+		}
+		if err := dl.writeChunk(chunkIndex, data); err != nil {
+			log.Printf("Failed to write chunk %d to disk: %v", chunkIndex, err)
+			remaining = append(remaining, chunkIndex)
+			continue
+		}
+		dl.state.markCompleted(chunkIndex, len(data))
+		copied++
+	}
+	if copied > 0 {
+		log.Printf("Copied %d chunk(s) from the local chunk store.", copied)
+	}
+	return remaining
+}
+
+// downloadChunk fetches, verifies and writes a single chunk, trying each peer that holds it
+// until one succeeds or the chunk is delivered by an endgame request.
+func (d *Downloader) downloadChunk(dl *download, chunkIndex int) error {
+	chunkLookupInfo, ok := dl.lookupResult.Chunks[chunkIndex]
+	if !ok || len(chunkLookupInfo.Peers)+len(chunkLookupInfo.UnreachablePeers) == 0 {
+		return fmt.Errorf("no peers or chunk hash found for chunk %d", chunkIndex)
+	}
+	ctx := dl.race.context(chunkIndex)
+
+	// Pick the least busy peer each time, preferring higher reputation on ties.
+	// If a transfer breaks off part-way, the next peer continues from the bytes already received.
+	// Peers the tracker couldn't reach may still be reachable from here, so they are the last resort.
+	peers, fallback := chunkLookupInfo.Peers, chunkLookupInfo.UnreachablePeers
+	tried := make(map[string]bool)
+	var partial []byte
+	for !dl.race.isDone(chunkIndex) {
+		peer, ok := dl.load.acquire(peers, tried)
+		if !ok && len(fallback) > 0 {
+			log.Printf("Trying peers the tracker could not reach for chunk %d.", chunkIndex)
+			peers, fallback = fallback, nil
+			continue
+		}
+		if !ok {
+			break
+		}
+		tried[peer.ID] = true
+		if !dl.race.start(chunkIndex, peer.ID) {
+			// An endgame request is already asking this peer.
+			dl.load.release(peer)
+			continue
+		}
+
+		resumed := len(partial) > 0
+		if resumed {
+			log.Printf("Resuming chunk %d at byte %d from peer %s (%s)", chunkIndex, len(partial), peer.ID, peer.Address)
+		} else {
+			log.Printf("Attempting to download chunk %d from peer %s (%s)", chunkIndex, peer.ID, peer.Address)
+		}
+		data, proof, err := d.downloadChunkFromPeer(ctx, peer, dl.fileHash, chunkIndex, dl.chunkID(chunkIndex), partial)
+		dl.load.release(peer)
+		if ctx.Err() != nil {
+			// Another peer delivered the chunk first; this request was cancelled, not failed.
+			dl.race.stop(chunkIndex, peer.ID, false)
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to download chunk %d from %s: %v. Trying next peer.", chunkIndex, peer.Address, err)
+			d.trackerClient.SubmitFeedback(peer.ID, dl.fileHash, chunkIndex, "FAILED_UPLOAD")
+			dl.race.stop(chunkIndex, peer.ID, true)
+			partial = data // Keep whatever arrived so the next peer can resume from it
+			continue
+		}
+
+		verified := d.deliverChunk(dl, chunkIndex, peer, data, proof, !resumed)
+		dl.race.stop(chunkIndex, peer.ID, !verified && !resumed)
+		partial = nil
+		if !verified && resumed {
+			// The bad bytes may have come from the earlier peer, so retry this one from scratch before blaming it.
+			log.Printf("Resumed chunk %d failed hash verification. Refetching it from peer %s from the start.", chunkIndex, peer.ID)
+			delete(tried, peer.ID)
+		}
+	}
+
+	// Endgame requests may still be working on the chunk after this worker ran out of peers.
+	if dl.race.wait(chunkIndex) {
+		return nil
+	}
+	return fmt.Errorf("failed to download, verify, and write chunk %d from any peer", chunkIndex)
+}
+
+// verifyChunk checks downloaded chunk data against the file hash using the peer's Merkle
+// proof. When the manifest's chunk hashes add up to the file hash they are just as
+// trustworthy, so a chunk matching its manifest hash needs no proof; this is what lets
+// peers holding the chunk as part of another file serve it. For files with legacy
+// identifiers the tracker's chunk hash is all there is to check against. Otherwise a
+// valid proof is required.
+func verifyChunk(dl *download, chunkIndex int, data []byte, proof []string) bool {
+	chunkHash := file.CalculateChunkHash(dl.alg, data)
+	if file.VerifyMerkleProof(dl.alg, dl.root, chunkIndex, dl.manifest.TotalChunks(), chunkHash, proof) {
+		return true
+	}
+	return dl.trusted && chunkHash == dl.manifest.ChunkHashes[chunkIndex]
+}
+
+// deliverChunk verifies a downloaded chunk and, if no other request beat it to it,
+// writes it to disk. It reports whether the data passed verification. Peers are only
+// blamed for bad data when blame is set, since resumed data is partly someone else's.
+func (d *Downloader) deliverChunk(dl *download, chunkIndex int, peer PeerInfo, data []byte, proof []string, blame bool) bool {
+	// Chunk hash verification
+	if !verifyChunk(dl, chunkIndex, data, proof) {
+		if blame {
+			log.Printf("Downloaded chunk %d from %s (hash %s) failed verification against file %s. Trying next peer.",
+				chunkIndex, peer.Address, file.CalculateChunkHash(dl.alg, data), dl.fileHash)
+			d.trackerClient.SubmitFeedback(peer.ID, dl.fileHash, chunkIndex, "FAILED_UPLOAD")
+		}
+		return false
+	}
+
+	// Credit the peer even if a racing request wins the write; its data was good.
+	d.trackerClient.SubmitFeedback(peer.ID, dl.fileHash, chunkIndex, "SUCCESS_UPLOAD")
+	if !dl.race.claim(chunkIndex) {
+		return true // Another peer's copy is already being written
+	}
+
+	// --- IMPORTANT: Write chunk directly to disk here! ---
+	if err := dl.writeChunk(chunkIndex, data); err != nil {
+		log.Printf("Failed to write chunk %d to disk: %v", chunkIndex, err)
+		dl.race.unclaim(chunkIndex)
+		return true
+	}
+	// --- END IMPORTANT ---
+
+	dl.state.markCompleted(chunkIndex, len(data)) // Mark as successfully written
+	dl.race.finish(chunkIndex)                    // Cancel any other requests for this chunk
+
+	if dl.store != nil {
+		if err := dl.store.Put(dl.chunkID(chunkIndex), data); err != nil {
+			log.Printf("Warning: failed to keep chunk %d in the chunk store: %v", chunkIndex, err)
+		}
+	}
+
+	log.Printf("Successfully downloaded, verified, and wrote chunk %d from peer %s", chunkIndex, peer.ID)
+	return true
+}
+
+// announceProgress announces the download's chunks in the chunk store to the tracker
+// every progressAnnounceInterval, and once more when stop is closed, whenever more are
+// held than last announced. The first announcement includes the manifest, in case the
+// download started from a manifest file the tracker hasn't seen.
+func (d *Downloader) announceProgress(dl *download, stop <-chan struct{}) {
+	ticker := time.NewTicker(progressAnnounceInterval)
+	defer ticker.Stop()
+
+	manifest, announced := dl.manifest, 0
+	for {
+		stopped := false
+		select {
+		case <-stop:
+			stopped = true
+		case <-ticker.C:
+		}
+
+		held, count := make([]bool, dl.manifest.TotalChunks()), 0
+		for i := range held {
+			if id := dl.chunkID(i); id != "" {
+				_, _, held[i] = dl.store.Locate(id)
+			}
+			if held[i] {
+				count++
+			}
+		}
+		if count > announced {
+			if err := d.trackerClient.AnnounceChunks(dl.fileHash, held, manifest); err != nil {
+				log.Printf("Warning: failed to announce downloaded chunks to tracker: %v", err)
+			} else {
+				manifest, announced = nil, count
+			}
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// endgame runs until stop is closed. Once few enough chunks are outstanding it requests
+// each of them from every other peer holding it that has a free request slot.
+func (d *Downloader) endgame(dl *download, stop <-chan struct{}) {
+	ticker := time.NewTicker(endgameInterval)
+	defer ticker.Stop()
+
+	announced := false
+	for {
+		outstanding := dl.race.outstanding()
+		if len(outstanding) > 0 && len(outstanding) <= endgameThreshold {
+			if !announced {
+				log.Printf("Entering endgame mode with %d chunk(s) outstanding.", len(outstanding))
+				announced = true
+			}
+			for _, chunkIndex := range outstanding {
+				for _, peer := range dl.lookupResult.Chunks[chunkIndex].Peers {
+					if !dl.load.tryAcquire(peer) {
+						continue
+					}
+					if !dl.race.start(chunkIndex, peer.ID) {
+						dl.load.release(peer)
+						continue
+					}
+					dl.racers.Add(1)
+					go d.raceChunk(dl, chunkIndex, peer)
+				}
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// raceChunk is a single endgame request for a chunk. The peer's slot must already be
+// acquired and the attempt started in the race.
+func (d *Downloader) raceChunk(dl *download, chunkIndex int, peer PeerInfo) {
+	defer dl.racers.Done()
+	ctx := dl.race.context(chunkIndex)
+
+	log.Printf("Endgame: requesting chunk %d from peer %s (%s)", chunkIndex, peer.ID, peer.Address)
+	data, proof, err := d.downloadChunkFromPeer(ctx, peer, dl.fileHash, chunkIndex, dl.chunkID(chunkIndex), nil)
+	dl.load.release(peer)
+	if ctx.Err() != nil {
+		dl.race.stop(chunkIndex, peer.ID, false)
+		return
+	}
+	if err != nil {
+		log.Printf("Endgame request for chunk %d from %s failed: %v", chunkIndex, peer.Address, err)
+		d.trackerClient.SubmitFeedback(peer.ID, dl.fileHash, chunkIndex, "FAILED_UPLOAD")
+		dl.race.stop(chunkIndex, peer.ID, true)
+		return
+	}
+	verified := d.deliverChunk(dl, chunkIndex, peer, data, proof, true)
+	dl.race.stop(chunkIndex, peer.ID, !verified)
+}
+
+// downloadChunkFromPeer streams one chunk from a peer over its pooled gRPC connection.
+// partial holds bytes of the chunk already received from an earlier peer, and the request
+// resumes right after them. On error the bytes received so far are returned along with it,
+// so the caller can continue from there with another peer. The chunk's Merkle proof is
+// returned with the data. Peers holding the chunk as part of another file are asked for
+// it under that file's hash and index. The chunk ID, if known, lets peers serve the chunk
+// from any file or their chunk store. Cancelling ctx aborts the transfer.
+func (d *Downloader) downloadChunkFromPeer(ctx context.Context, peer PeerInfo, fileHash string, chunkIndex int, chunkID string, partial []byte) ([]byte, []string, error) {
+	fileHash, chunkIndex = peer.source(fileHash, chunkIndex)
+	conn, release, err := d.conns.Get(peer)
+	if err != nil {
+		return partial, nil, fmt.Errorf("did not connect to peer %s (%s): %v", peer.ID, peer.Address, err)
+	}
+	defer release()
+
+	c := pb.NewPeerServiceClient(conn)
+	// Rather than a deadline for the whole chunk, give up once no block has arrived for blockTimeout.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := time.AfterFunc(blockTimeout, cancel)
+	defer timer.Stop()
+
+	stream, err := c.StreamChunk(ctx, &pb.ChunkRangeRequest{
+		FileHash:   fileHash,
+		ChunkIndex: int32(chunkIndex),
+		Offset:     int64(len(partial)),
+		ChunkId:    chunkID,
+	})
+	if err != nil {
+		return partial, nil, fmt.Errorf("could not download chunk %d from peer %s: %v", chunkIndex, peer.ID, err)
+	}
+
+	data := partial
+	var proof []string
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			return data, proof, nil
+		}
+		if err != nil {
+			if status.Code(err) == codes.Unimplemented && len(partial) == 0 {
+				// Peers running an older version only support the unary RPC.
+				data, proof, err := downloadWholeChunk(ctx, c, peer, fileHash, chunkIndex, chunkID)
+				if err == nil {
+					err = d.downloads.WaitN(ctx, peer.ID, len(data))
+				}
+				return data, proof, err
+			}
+			return data, nil, fmt.Errorf("could not download chunk %d from peer %s: %v", chunkIndex, peer.ID, err)
+		}
+		// Time spent waiting on the rate limiter must not count as the peer being idle.
+		timer.Stop()
+		if err := d.downloads.WaitN(ctx, peer.ID, len(block.GetData())); err != nil {
+			return data, nil, err
+		}
+		timer.Reset(blockTimeout)
+
+		if block.GetOffset() != int64(len(data)) {
+			return data, nil, fmt.Errorf("peer %s sent block at offset %d of chunk %d, expected %d", peer.ID, block.GetOffset(), chunkIndex, len(data))
+		}
+		if block.GetChunkSize() > maxChunkSize || int64(len(data)+len(block.GetData())) > block.GetChunkSize() {
+			return data, nil, fmt.Errorf("peer %s sent more data than chunk %d can hold", peer.ID, chunkIndex)
+		}
+		if data == nil {
+			data = make([]byte, 0, block.GetChunkSize())
+		}
+		data = append(data, block.GetData()...)
+		if len(block.GetProof()) > 0 {
+			proof = block.GetProof()
+		}
+	}
+}
+
+// downloadWholeChunk fetches a chunk and its Merkle proof with the unary DownloadChunk RPC.
+func downloadWholeChunk(ctx context.Context, c pb.PeerServiceClient, peer PeerInfo, fileHash string, chunkIndex int, chunkID string) ([]byte, []string, error) {
+	ctx, cancel := context.WithTimeout(ctx, blockTimeout)
+	defer cancel()
+
+	r, err := c.DownloadChunk(ctx, &pb.ChunkRequest{FileHash: fileHash, ChunkIndex: int32(chunkIndex), ChunkId: chunkID})
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not download chunk %d from peer %s: %v", chunkIndex, peer.ID, err)
+	}
+	return r.GetChunkData(), r.GetProof(), nil
+}
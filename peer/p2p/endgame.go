@@ -0,0 +1,155 @@
+package p2p
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// endgameThreshold is the number of outstanding chunks at which a download enters
+	// endgame mode and starts requesting each of them from several peers at once.
+	endgameThreshold = 4
+	// endgameInterval is how often endgame mode looks for idle peers to add to the race.
+	endgameInterval = time.Second
+)
+
+// chunkRace tracks the chunks still outstanding in a download and which peers are
+// working on each. In endgame mode the same chunk is requested from several peers;
+// the first verified copy wins and the other requests are cancelled.
+type chunkRace struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	entries map[int]*raceEntry
+}
+
+type raceEntry struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	claimed bool            // A verified copy is being written to disk
+	done    bool            // The chunk is on disk
+	active  map[string]bool // Peers currently being asked for the chunk
+	failed  map[string]bool // Peers that already failed to deliver it
+}
+
+func newChunkRace() *chunkRace {
+	r := &chunkRace{entries: make(map[int]*raceEntry)}
+	r.cond = sync.NewCond(&r.mu)
+	return r
+}
+
+// add registers an outstanding chunk.
+func (r *chunkRace) add(chunkIndex int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.mu.Lock()
+	r.entries[chunkIndex] = &raceEntry{
+		ctx:    ctx,
+		cancel: cancel,
+		active: make(map[string]bool),
+		failed: make(map[string]bool),
+	}
+	r.mu.Unlock()
+}
+
+// context returns the context that requests for a chunk run under. It is cancelled
+// as soon as one of them delivers the chunk.
+func (r *chunkRace) context(chunkIndex int) context.Context {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries[chunkIndex].ctx
+}
+
+// start records that a peer is being asked for a chunk. It returns false if the chunk
+// is already done, or the peer is already working on it or has failed it.
+func (r *chunkRace) start(chunkIndex int, peerID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entries[chunkIndex]
+	if e.done || e.active[peerID] || e.failed[peerID] {
+		return false
+	}
+	e.active[peerID] = true
+	return true
+}
+
+// stop records the end of a peer's attempt at a chunk.
+func (r *chunkRace) stop(chunkIndex int, peerID string, failed bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entries[chunkIndex]
+	delete(e.active, peerID)
+	if failed {
+		e.failed[peerID] = true
+	}
+	r.cond.Broadcast()
+}
+
+// claim grants the right to write a verified copy of a chunk to exactly one attempt.
+func (r *chunkRace) claim(chunkIndex int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entries[chunkIndex]
+	if e.claimed || e.done {
+		return false
+	}
+	e.claimed = true
+	return true
+}
+
+// unclaim gives up a claim after the write failed, letting another attempt try.
+func (r *chunkRace) unclaim(chunkIndex int) {
+	r.mu.Lock()
+	r.entries[chunkIndex].claimed = false
+	r.mu.Unlock()
+}
+
+// finish marks a chunk as on disk and cancels every other request for it.
+func (r *chunkRace) finish(chunkIndex int) {
+	r.mu.Lock()
+	e := r.entries[chunkIndex]
+	e.done = true
+	r.mu.Unlock()
+	e.cancel()
+	r.cond.Broadcast()
+}
+
+// isDone reports whether a chunk is on disk.
+func (r *chunkRace) isDone(chunkIndex int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.entries[chunkIndex].done
+}
+
+// wait blocks until a chunk is done or nobody is working on it any more,
+// and reports whether it is done.
+func (r *chunkRace) wait(chunkIndex int) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.entries[chunkIndex]
+	for !e.done && len(e.active) > 0 {
+		r.cond.Wait()
+	}
+	return e.done
+}
+
+// outstanding returns the chunks that are not done yet.
+func (r *chunkRace) outstanding() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var indices []int
+	for index, e := range r.entries {
+		if !e.done {
+			indices = append(indices, index)
+		}
+	}
+	return indices
+}
+
+// cancelAll cancels every request that is still running.
+func (r *chunkRace) cancelAll() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.entries {
+		e.cancel()
+	}
+}
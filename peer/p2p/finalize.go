@@ -0,0 +1,102 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ShreyamKundu/peernet/peer/file"
+)
+
+// ErrFileHashMismatch is returned when every chunk verified but the assembled file
+// doesn't hash to the requested file hash. Retrying won't help, since the chunk
+// metadata itself was wrong.
+var ErrFileHashMismatch = errors.New("downloaded file does not match the requested file hash")
+
+// failedSuffix marks downloads that were assembled but failed whole-file verification.
+const failedSuffix = ".failed"
+
+// partialPath returns where a file is assembled while it downloads.
+func partialPath(outputDir, fileHash string) string {
+	return filepath.Join(outputDir, fileHash+".download")
+}
+
+// finalizeDownload verifies the assembled file against the requested hash and renames
+// it to its real name in the same directory, without overwriting an existing file.
+// The file is rehashed with the algorithm and chunking it was shared with.
+// A file that fails verification is renamed to <hash>.failed instead.
+func finalizeDownload(partial, fileHash string, alg *file.HashAlgorithm, chunking file.Chunking, fileName string) (string, error) {
+	calculated, err := file.HashFile(partial, alg, chunking)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash downloaded file: %v", err)
+	}
+	verified := file.SameID(calculated, fileHash)
+	if !verified && alg == file.SHA256 {
+		// Files shared before identifiers became Merkle roots are named by their flat hash.
+		if legacy, err := file.LegacyFileHash(partial); err == nil && legacy == fileHash {
+			verified = true
+		}
+	}
+	if !verified {
+		failed := strings.TrimSuffix(partial, ".download") + failedSuffix
+		if err := os.Rename(partial, failed); err != nil {
+			log.Printf("Warning: failed to mark %s as failed: %v", partial, err)
+			failed = partial
+		}
+		return "", fmt.Errorf("%w: got %s, kept as %s", ErrFileHashMismatch, calculated, failed)
+	}
+
+	name := safeFileName(fileName)
+	if name == "" {
+		name = fileHash
+	}
+	return renameNoClobber(partial, filepath.Dir(partial), name)
+}
+
+// safeFileName reduces a tracker-supplied name to a plain file name, so it can't
+// point outside the output directory. It returns "" if nothing usable is left.
+func safeFileName(name string) string {
+	name = filepath.Base(filepath.Clean(strings.ReplaceAll(name, "\\", "/")))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return ""
+	}
+	return name
+}
+
+// renameNoClobber moves src to dir/name, or to "name (1)", "name (2)" and so on if
+// that is taken. Hard-linking fails atomically when the target exists, so a file
+// created concurrently under the same name is never overwritten.
+func renameNoClobber(src, dir, name string) (string, error) {
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 0; i < 1000; i++ {
+		candidate := filepath.Join(dir, name)
+		if i > 0 {
+			candidate = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+		}
+
+		err := os.Link(src, candidate)
+		if err == nil {
+			if err := os.Remove(src); err != nil {
+				log.Printf("Warning: failed to remove %s: %v", src, err)
+			}
+			return candidate, nil
+		}
+		if os.IsExist(err) {
+			continue
+		}
+
+		// Some filesystems don't support hard links; fall back to check-then-rename.
+		if _, statErr := os.Lstat(candidate); statErr == nil {
+			continue
+		}
+		if err := os.Rename(src, candidate); err != nil {
+			return "", fmt.Errorf("failed to rename %s to %s: %v", src, candidate, err)
+		}
+		return candidate, nil
+	}
+	return "", fmt.Errorf("no free file name for %s in %s", name, dir)
+}
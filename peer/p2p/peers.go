@@ -0,0 +1,80 @@
+package p2p
+
+import "sync"
+
+// peerLoad tracks how many requests are in flight to each peer during a download
+// and caps them at a per-peer limit, so work is spread across every peer holding
+// a chunk instead of piling onto the highest-reputation one.
+type peerLoad struct {
+	limit int
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	inFlight map[string]int // peer ID -> outstanding requests
+}
+
+func newPeerLoad(limit int) *peerLoad {
+	l := &peerLoad{
+		limit:    limit,
+		inFlight: make(map[string]int),
+	}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire reserves a request slot on the least busy candidate that has not been tried yet.
+// Candidates are expected in order of preference (reputation), which breaks ties.
+// It blocks while every remaining candidate is at its limit, and returns false once
+// all candidates have been tried.
+func (l *peerLoad) acquire(candidates []PeerInfo, tried map[string]bool) (PeerInfo, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		best := -1
+		remaining := false
+		for i, peer := range candidates {
+			if tried[peer.ID] {
+				continue
+			}
+			remaining = true
+			if l.inFlight[peer.ID] >= l.limit {
+				continue
+			}
+			if best == -1 || l.inFlight[peer.ID] < l.inFlight[candidates[best].ID] {
+				best = i
+			}
+		}
+		if !remaining {
+			return PeerInfo{}, false
+		}
+		if best != -1 {
+			peer := candidates[best]
+			l.inFlight[peer.ID]++
+			return peer, true
+		}
+		l.cond.Wait()
+	}
+}
+
+// release frees a slot previously reserved with acquire.
+func (l *peerLoad) release(peer PeerInfo) {
+	l.mu.Lock()
+	l.inFlight[peer.ID]--
+	if l.inFlight[peer.ID] <= 0 {
+		delete(l.inFlight, peer.ID)
+	}
+	l.mu.Unlock()
+	l.cond.Broadcast()
+}
+
+// tryAcquire reserves a request slot on peer if it is below its limit, without blocking.
+func (l *peerLoad) tryAcquire(peer PeerInfo) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inFlight[peer.ID] >= l.limit {
+		return false
+	}
+	l.inFlight[peer.ID]++
+	return true
+}
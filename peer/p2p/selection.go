@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+)
+
+// ChunkOrder decides the order in which a download requests its missing chunks.
+type ChunkOrder interface {
+	// Name is the identifier used on the command line and in the config file.
+	Name() string
+	// Order returns the given chunk indices in the order they should be requested.
+	Order(chunks []int, lookupResult *LookupResult) []int
+}
+
+// DefaultChunkOrder is the policy used when none is configured.
+const DefaultChunkOrder = "rarest-first"
+
+// ParseChunkOrder returns the ordering policy with the given name.
+func ParseChunkOrder(name string) (ChunkOrder, error) {
+	switch name {
+	case "sequential":
+		return SequentialOrder{}, nil
+	case "random":
+		return RandomOrder{}, nil
+	case "rarest-first", "":
+		return RarestFirstOrder{}, nil
+	default:
+		return nil, fmt.Errorf("unknown chunk order %q (expected sequential, random or rarest-first)", name)
+	}
+}
+
+// SequentialOrder requests chunks in index order, which suits streaming playback.
+type SequentialOrder struct{}
+
+func (SequentialOrder) Name() string { return "sequential" }
+
+func (SequentialOrder) Order(chunks []int, lookupResult *LookupResult) []int {
+	ordered := append([]int(nil), chunks...)
+	sort.Ints(ordered)
+	return ordered
+}
+
+// RandomOrder requests chunks in a random order, spreading load across the swarm.
+type RandomOrder struct{}
+
+func (RandomOrder) Name() string { return "random" }
+
+func (RandomOrder) Order(chunks []int, lookupResult *LookupResult) []int {
+	ordered := append([]int(nil), chunks...)
+	rand.Shuffle(len(ordered), func(i, j int) { ordered[i], ordered[j] = ordered[j], ordered[i] })
+	return ordered
+}
+
+// RarestFirstOrder requests the chunks held by the fewest peers first, so the
+// chunks most likely to disappear from the swarm are secured early. Peers the tracker
+// couldn't reach count too, since the downloader still tries them. Chunks with
+// equal availability are shuffled so that downloaders don't all chase the same one.
+type RarestFirstOrder struct{}
+
+func (RarestFirstOrder) Name() string { return "rarest-first" }
+
+func (RarestFirstOrder) Order(chunks []int, lookupResult *LookupResult) []int {
+	availability := func(chunkIndex int) int {
+		info := lookupResult.Chunks[chunkIndex]
+		return len(info.Peers) + len(info.UnreachablePeers)
+	}
+	ordered := RandomOrder{}.Order(chunks, lookupResult)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return availability(ordered[i]) < availability(ordered[j])
+	})
+	return ordered
+}
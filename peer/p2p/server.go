@@ -7,97 +7,479 @@ import (
 	"log"
 	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 
-	"github.com/ShreyamKundu/peernet/peer/file" // Import the file package to access ChunkSize and VerifyChunk
+	"github.com/ShreyamKundu/peernet/peer/bandwidth"
+	"github.com/ShreyamKundu/peernet/peer/file" // Import the file package for manifests and VerifyChunk
+	"github.com/ShreyamKundu/peernet/peer/store"
 	pb "github.com/ShreyamKundu/peernet/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
+// SharedFile describes one file or directory in the server's catalog.
+type SharedFile struct {
+	Path          string         `json:"path"`                     // The full path to the file or directory being served
+	FileHash      string         `json:"file_hash"`                // The overall hash of the file being served
+	FileSize      int64          `json:"file_size"`                // Exact size of the file in bytes
+	ChunkSize     int            `json:"chunk_size"`               // Size of every fixed-size chunk except possibly the last, else the largest chunk
+	TotalChunks   int            `json:"total_chunks"`             // Total number of chunks for the file
+	ChunkHashes   map[int]string `json:"chunk_hashes"`             // Maps chunkIndex to its expected chunkHash (metadata only)
+	HashAlgorithm string         `json:"hash_algorithm,omitempty"` // Algorithm of the chunk hashes; empty means SHA-256
+	Chunking      string         `json:"chunking,omitempty"`       // How the file was chunked; empty means fixed-size chunks
+	ChunkSizes    []int          `json:"chunk_sizes,omitempty"`    // Size of every chunk, for variable-size chunks and directories only
+
+	Files []file.ManifestFile `json:"files,omitempty"` // The files of a shared directory
+
+	alg    *file.HashAlgorithm
+	tree   *file.MerkleTree // Produces the inclusion proofs sent with each chunk
+	layout *file.Manifest   // Locates chunks within the file or directory
+}
+
+// proof returns the Merkle inclusion proof of a chunk, or nil if it can't be produced.
+func (f *SharedFile) proof(chunkIndex int) []string {
+	if f.tree == nil {
+		return nil
+	}
+	return f.tree.Proof(chunkIndex)
+}
+
+// Manifest returns the manifest the file is shared with.
+func (f *SharedFile) Manifest() *file.Manifest {
+	return f.layout
+}
+
+// chunkRange returns the path of the file holding a chunk, and the chunk's byte offset
+// and length within it.
+func (f *SharedFile) chunkRange(chunkIndex int) (string, int64, int64) {
+	relPath, offset := f.layout.LocateChunk(chunkIndex)
+	path := f.Path
+	if relPath != "" {
+		path = filepath.Join(f.Path, filepath.FromSlash(relPath))
+	}
+	return path, offset, max(0, int64(f.layout.ChunkLength(chunkIndex)))
+}
+
+// chunkLocation is where a chunk can be read from one of the shared files.
+type chunkLocation struct {
+	shared *SharedFile
+	index  int
+}
+
 // Server implements the gRPC PeerService.
+// It serves chunks for every file in its catalog, keyed by file hash. Requests that
+// carry a chunk ID are also served from any shared file containing that chunk, or
+// from the local chunk store.
 type Server struct {
 	pb.UnimplementedPeerServiceServer
-	sharedFilePath string              // The full path to the file being served
-	fileHash       string              // The overall hash of the file being served
-	totalChunks    int                 // Total number of chunks for the file
-	chunkHashes    map[int]string      // Maps chunkIndex to its expected chunkHash (metadata only)
+
+	mu     sync.RWMutex
+	files  map[string]*SharedFile
+	chunks map[string][]chunkLocation // Chunk ID -> shared files containing it
+	store  *store.Store               // Nil when no chunk store is used
+	peerID string                     // This peer's ID, reported to the tracker's pings
+
+	uploads *bandwidth.Limiter // Throttles outgoing chunk data, globally and per remote peer
+
+	grpcServer *grpc.Server
+}
+
+// NewGRPCServer creates a new gRPC server instance with an empty catalog and no upload limits.
+// Files are added and removed at runtime with AddFile and RemoveFile.
+func NewGRPCServer() *Server {
+	return &Server{
+		files:   make(map[string]*SharedFile),
+		chunks:  make(map[string][]chunkLocation),
+		uploads: bandwidth.NewLimiter(0, 0),
+	}
+}
+
+// SetStore makes the server serve chunks from a local chunk store as well as from
+// shared files.
+func (s *Server) SetStore(st *store.Store) {
+	s.mu.Lock()
+	s.store = st
+	s.mu.Unlock()
 }
 
-// NewGRPCServer creates a new gRPC server instance.
-// It now takes the full file path, its overall hash, total chunks, and a slice of ChunkInfo
-// (which contains chunk indices and their hashes, but not the actual data for the server to store).
-func NewGRPCServer(filePath, fileHash string, totalChunks int, chunks []file.ChunkInfo) *Server {
+// SetPeerID sets the peer ID the server answers pings with.
+func (s *Server) SetPeerID(peerID string) {
+	s.mu.Lock()
+	s.peerID = peerID
+	s.mu.Unlock()
+}
+
+// Ping answers the tracker checking that this peer is reachable at its registered address.
+func (s *Server) Ping(ctx context.Context, in *pb.PingRequest) (*pb.PingResponse, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &pb.PingResponse{PeerId: s.peerID}, nil
+}
+
+// UploadLimiter returns the limiter applied to served chunk data. Its limits can be
+// changed while the server is running.
+func (s *Server) UploadLimiter() *bandwidth.Limiter {
+	return s.uploads
+}
+
+// remotePeer identifies the requesting peer for per-peer limits. Under mutual TLS this
+// is the peer ID from its verified certificate; otherwise only the host is used, since
+// each connection comes from a different ephemeral port.
+func remotePeer(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "unknown"
+	}
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+		return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// AddFile adds a file to the catalog, replacing any previous entry with the same hash.
+// Only the manifest's layout and chunk hashes are kept; chunk data is read from disk on demand.
+func (s *Server) AddFile(filePath string, manifest *file.Manifest) *SharedFile {
 	chunkMap := make(map[int]string)
-	for _, c := range chunks {
-		chunkMap[c.Index] = c.Hash // Store only the chunk hash, not the data
+	for index, hash := range manifest.ChunkHashes {
+		chunkMap[index] = hash // Store only the chunk hash, not the data
 	}
-	return &Server{
-		sharedFilePath: filePath,
-		fileHash:       fileHash,
-		totalChunks:    totalChunks,
-		chunkHashes:    chunkMap,
+	alg, err := manifest.Algorithm()
+	if err != nil {
+		log.Printf("Warning: %v for %s, assuming %s", err, filePath, file.SHA256.Name)
+		alg = file.SHA256
+	}
+	shared := &SharedFile{
+		Path:          filePath,
+		FileHash:      manifest.FileHash,
+		FileSize:      manifest.FileSize,
+		ChunkSize:     manifest.ChunkSize,
+		HashAlgorithm: alg.Name,
+		Chunking:      manifest.Chunking,
+		ChunkSizes:    manifest.ChunkSizes,
+		Files:         manifest.Files,
+		TotalChunks:   manifest.TotalChunks(),
+		ChunkHashes:   chunkMap,
+		alg:           alg,
+		layout:        manifest,
 	}
+	tree, err := file.NewMerkleTree(alg, manifest.ChunkHashes)
+	if err != nil {
+		log.Printf("Warning: cannot build Merkle tree for %s, chunks will be served without proofs: %v", filePath, err)
+	} else {
+		shared.tree = tree
+	}
+
+	s.mu.Lock()
+	if previous, ok := s.files[shared.FileHash]; ok {
+		s.unindexLocked(previous)
+	}
+	s.files[shared.FileHash] = shared
+	for index, hash := range manifest.ChunkHashes {
+		id := alg.FormatID(hash)
+		s.chunks[id] = append(s.chunks[id], chunkLocation{shared: shared, index: index})
+	}
+	s.mu.Unlock()
+
+	log.Printf("Now serving file %s (hash: %s, %d chunks)", filePath, shared.FileHash, shared.TotalChunks)
+	return shared
 }
 
-// DownloadChunk serves a requested file chunk by reading it directly from disk.
-func (s *Server) DownloadChunk(ctx context.Context, in *pb.ChunkRequest) (*pb.ChunkResponse, error) {
-	log.Printf("Received request for chunk %d of file %s", in.GetChunkIndex(), in.GetFileHash())
+// RemoveFile removes a file from the catalog. It reports whether the file was being served.
+func (s *Server) RemoveFile(fileHash string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	requestedFileHash := in.GetFileHash()
-	requestedChunkIndex := int(in.GetChunkIndex())
+	shared, ok := s.files[fileHash]
+	if !ok {
+		return false
+	}
+	delete(s.files, fileHash)
+	s.unindexLocked(shared)
+	log.Printf("Stopped serving file %s (hash: %s)", shared.Path, fileHash)
+	return true
+}
 
-	// 1. Validate the request against the file this server is sharing
-	if requestedFileHash != s.fileHash {
-		return nil, fmt.Errorf("this peer is not sharing file with hash %s", requestedFileHash)
+// unindexLocked drops a shared file's chunks from the chunk ID index. s.mu must be held.
+func (s *Server) unindexLocked(shared *SharedFile) {
+	for _, hash := range shared.ChunkHashes {
+		id := shared.alg.FormatID(hash)
+		locations := s.chunks[id][:0]
+		for _, loc := range s.chunks[id] {
+			if loc.shared != shared {
+				locations = append(locations, loc)
+			}
+		}
+		if len(locations) == 0 {
+			delete(s.chunks, id)
+		} else {
+			s.chunks[id] = locations
+		}
 	}
+}
 
-	if requestedChunkIndex < 0 || requestedChunkIndex >= s.totalChunks {
-		return nil, fmt.Errorf("invalid chunk index %d for file %s (total chunks: %d)", requestedChunkIndex, requestedFileHash, s.totalChunks)
+// File returns the catalog entry for a file hash, if any.
+func (s *Server) File(fileHash string) (*SharedFile, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	shared, ok := s.files[fileHash]
+	return shared, ok
+}
+
+// Files returns a snapshot of the catalog, ordered by path.
+func (s *Server) Files() []*SharedFile {
+	s.mu.RLock()
+	files := make([]*SharedFile, 0, len(s.files))
+	for _, shared := range s.files {
+		files = append(files, shared)
 	}
+	s.mu.RUnlock()
 
-	// Get the expected hash for verification
-	expectedChunkHash, ok := s.chunkHashes[requestedChunkIndex]
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// BlockSize is the size of the blocks a chunk is split into when streamed.
+const BlockSize = 64 * 1024
+
+// chunkSource is where the data of a requested chunk is read from.
+type chunkSource struct {
+	path         string
+	offset       int64
+	length       int64
+	alg          *file.HashAlgorithm
+	expectedHash string
+	proof        []string // Nil when the chunk comes from outside the requested file
+}
+
+// sharedChunk returns the source of a chunk within a shared file.
+func sharedChunk(shared *SharedFile, chunkIndex int, withProof bool) *chunkSource {
+	path, offset, length := shared.chunkRange(chunkIndex)
+	src := &chunkSource{
+		path:         path,
+		offset:       offset,
+		length:       length,
+		alg:          shared.alg,
+		expectedHash: shared.ChunkHashes[chunkIndex],
+	}
+	if withProof {
+		src.proof = shared.proof(chunkIndex)
+	}
+	return src
+}
+
+// lookupChunk validates a chunk request against the catalog and returns where to read
+// the chunk from. A file this server doesn't share is no obstacle when the request
+// carries a chunk ID the server holds some other way.
+func (s *Server) lookupChunk(fileHash string, chunkIndex int, chunkID string) (*chunkSource, error) {
+	// 1. Validate the request against the files this server is sharing
+	shared, ok := s.File(fileHash)
 	if !ok {
+		if src := s.lookupChunkID(chunkID); src != nil {
+			return src, nil
+		}
+		return nil, status.Errorf(codes.NotFound, "this peer is not sharing file with hash %s", fileHash)
+	}
+
+	if chunkIndex < 0 || chunkIndex >= shared.TotalChunks {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid chunk index %d for file %s (total chunks: %d)", chunkIndex, fileHash, shared.TotalChunks)
+	}
+
+	// Get the expected hash for verification
+	if _, ok := shared.ChunkHashes[chunkIndex]; !ok {
 		// This indicates an inconsistency in the server's file metadata
-		return nil, fmt.Errorf("metadata for chunk %d of file %s not found on this peer", requestedChunkIndex, requestedFileHash)
+		return nil, status.Errorf(codes.Internal, "metadata for chunk %d of file %s not found on this peer", chunkIndex, fileHash)
+	}
+	return sharedChunk(shared, chunkIndex, true), nil
+}
+
+// lookupChunkID finds a chunk by ID in the shared files or the chunk store.
+// It returns nil if the server doesn't hold it.
+func (s *Server) lookupChunkID(chunkID string) *chunkSource {
+	if chunkID == "" {
+		return nil
+	}
+	s.mu.RLock()
+	locations := s.chunks[chunkID]
+	st := s.store
+	var loc chunkLocation
+	if len(locations) > 0 {
+		loc = locations[0]
+	}
+	s.mu.RUnlock()
+	if loc.shared != nil {
+		return sharedChunk(loc.shared, loc.index, false)
+	}
+
+	if st == nil {
+		return nil
+	}
+	alg, digest, err := file.ParseID(chunkID)
+	if err != nil {
+		return nil
+	}
+	path, size, ok := st.Locate(chunkID)
+	if !ok {
+		return nil
+	}
+	return &chunkSource{path: path, length: size, alg: alg, expectedHash: digest}
+}
+
+// DownloadChunk serves a requested file chunk by reading it directly from disk.
+func (s *Server) DownloadChunk(ctx context.Context, in *pb.ChunkRequest) (*pb.ChunkResponse, error) {
+	log.Printf("Received request for chunk %d of file %s", in.GetChunkIndex(), in.GetFileHash())
+
+	requestedFileHash := in.GetFileHash()
+	requestedChunkIndex := int(in.GetChunkIndex())
+
+	src, err := s.lookupChunk(requestedFileHash, requestedChunkIndex, in.GetChunkId())
+	if err != nil {
+		return nil, err
 	}
 
 	// 2. Open the file from disk
-	fileHandle, err := os.Open(s.sharedFilePath)
+	fileHandle, err := os.Open(src.path)
 	if err != nil {
-		log.Printf("Error opening shared file %s: %v", s.sharedFilePath, err)
+		log.Printf("Error opening shared file %s: %v", src.path, err)
 		return nil, fmt.Errorf("failed to open shared file on disk")
 	}
 	defer fileHandle.Close()
 
-	// 3. Calculate the offset and read the chunk data
-	offset := int64(requestedChunkIndex) * file.ChunkSize // Use the exported ChunkSize
-	buffer := make([]byte, file.ChunkSize)
+	// 3. Read the chunk data at its offset
+	buffer := make([]byte, src.length)
 
-	bytesRead, err := fileHandle.ReadAt(buffer, offset)
+	bytesRead, err := fileHandle.ReadAt(buffer, src.offset)
 	if err != nil && err != io.EOF {
-		log.Printf("Error reading chunk %d from file %s at offset %d: %v", requestedChunkIndex, s.sharedFilePath, offset, err)
+		log.Printf("Error reading chunk %d from file %s at offset %d: %v", requestedChunkIndex, src.path, src.offset, err)
 		return nil, fmt.Errorf("failed to read chunk data from disk")
 	}
 
 	chunkData := buffer[:bytesRead]
 
 	// 4. Verify the chunk data's integrity before sending
-	if !file.VerifyChunk(chunkData, expectedChunkHash) {
-		log.Printf("Chunk %d of file %s hash mismatch. Expected %s, calculated %s.", requestedChunkIndex, requestedFileHash, expectedChunkHash, file.CalculateChunkHash(chunkData))
+	if !file.VerifyChunk(src.alg, chunkData, src.expectedHash) {
+		log.Printf("Chunk %d of file %s hash mismatch. Expected %s, calculated %s.", requestedChunkIndex, requestedFileHash, src.expectedHash, file.CalculateChunkHash(src.alg, chunkData))
 		return nil, fmt.Errorf("chunk data integrity check failed on server side")
 	}
 
-	return &pb.ChunkResponse{ChunkData: chunkData}, nil
+	// 5. Respect the upload limits before handing the data to gRPC
+	if err := s.uploads.WaitN(ctx, remotePeer(ctx), len(chunkData)); err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+
+	return &pb.ChunkResponse{ChunkData: chunkData, Proof: src.proof}, nil
+}
+
+// StreamChunk serves a chunk, or a byte range of it, as a sequence of BlockSize blocks.
+// The whole chunk is read and hashed as it goes so the data can be verified without
+// holding the chunk in memory; if verification fails the stream ends with an error
+// and the receiver must discard what it got.
+func (s *Server) StreamChunk(in *pb.ChunkRangeRequest, stream pb.PeerService_StreamChunkServer) error {
+	requestedFileHash := in.GetFileHash()
+	requestedChunkIndex := int(in.GetChunkIndex())
+	log.Printf("Received stream request for chunk %d of file %s (offset %d, length %d)", requestedChunkIndex, requestedFileHash, in.GetOffset(), in.GetLength())
+
+	src, err := s.lookupChunk(requestedFileHash, requestedChunkIndex, in.GetChunkId())
+	if err != nil {
+		return err
+	}
+
+	fileHandle, err := os.Open(src.path)
+	if err != nil {
+		log.Printf("Error opening shared file %s: %v", src.path, err)
+		return status.Error(codes.Unavailable, "failed to open shared file on disk")
+	}
+	defer fileHandle.Close()
+
+	chunkStart, chunkSize := src.offset, src.length
+
+	rangeStart := in.GetOffset()
+	rangeEnd := chunkSize
+	if in.GetLength() > 0 {
+		rangeEnd = min(rangeStart+in.GetLength(), chunkSize)
+	}
+	if rangeStart < 0 || rangeStart > chunkSize {
+		return status.Errorf(codes.OutOfRange, "offset %d is outside chunk %d (size %d)", rangeStart, requestedChunkIndex, chunkSize)
+	}
+
+	remote := remotePeer(stream.Context())
+	hasher := file.NewChunkHasher(src.alg)
+	reader := io.NewSectionReader(fileHandle, chunkStart, chunkSize)
+	buffer := make([]byte, BlockSize)
+	proof := src.proof
+	var pos int64
+	for pos < chunkSize {
+		n, err := io.ReadFull(reader, buffer[:min(int64(BlockSize), chunkSize-pos)])
+		if err != nil {
+			log.Printf("Error reading chunk %d from file %s at offset %d: %v", requestedChunkIndex, src.path, chunkStart+pos, err)
+			return status.Error(codes.DataLoss, "failed to read chunk data from disk")
+		}
+		block := buffer[:n]
+		hasher.Write(block)
+
+		// Only send the part of the block that falls inside the requested range.
+		blockStart, blockEnd := max(pos, rangeStart), min(pos+int64(n), rangeEnd)
+		if blockStart < blockEnd {
+			if err := s.uploads.WaitN(stream.Context(), remote, int(blockEnd-blockStart)); err != nil {
+				return status.FromContextError(err).Err()
+			}
+			if err := stream.Send(&pb.ChunkBlock{
+				Offset:    blockStart,
+				Data:      block[blockStart-pos : blockEnd-pos],
+				ChunkSize: chunkSize,
+				Proof:     proof,
+			}); err != nil {
+				return err
+			}
+			proof = nil // Only the first block carries the proof
+		}
+		pos += int64(n)
+	}
+
+	// Verify the chunk data's integrity now that all of it has been read
+	if calculated := hasher.Sum(); calculated != src.expectedHash {
+		log.Printf("Chunk %d of file %s hash mismatch. Expected %s, calculated %s.", requestedChunkIndex, requestedFileHash, src.expectedHash, calculated)
+		return status.Error(codes.DataLoss, "chunk data integrity check failed on server side")
+	}
+	return nil
 }
 
-// Start begins listening for gRPC requests.
-func (s *Server) Start(port string) error {
+// Start begins listening for gRPC requests. It blocks until the server is stopped.
+// creds secures the transport; nil serves plaintext.
+func (s *Server) Start(port string, creds credentials.TransportCredentials) error {
 	lis, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
 	if err != nil {
 		return fmt.Errorf("failed to listen: %v", err)
 	}
-	grpcServer := grpc.NewServer()
+	var opts []grpc.ServerOption
+	if creds != nil {
+		opts = append(opts, grpc.Creds(creds))
+	}
+	grpcServer := grpc.NewServer(opts...)
 	pb.RegisterPeerServiceServer(grpcServer, s)
-	log.Printf("gRPC server started on port %s, serving file %s (hash: %s)", port, s.sharedFilePath, s.fileHash)
+
+	s.mu.Lock()
+	s.grpcServer = grpcServer
+	s.mu.Unlock()
+
+	log.Printf("gRPC server started on port %s (tls: %t), serving %d file(s)", port, creds != nil, len(s.Files()))
 	return grpcServer.Serve(lis)
 }
+
+// Stop gracefully stops the gRPC server, letting in-flight transfers finish.
+func (s *Server) Stop() {
+	s.mu.RLock()
+	grpcServer := s.grpcServer
+	s.mu.RUnlock()
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+}
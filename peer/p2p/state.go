@@ -0,0 +1,166 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ShreyamKundu/peernet/peer/file"
+)
+
+// stateSaveInterval bounds how often progress is flushed to the sidecar file while
+// chunks are arriving. At most this much progress is lost if the process is killed.
+const stateSaveInterval = 2 * time.Second
+
+// downloadState records which chunks of a partial download are already on disk.
+// It lives in a sidecar file next to the .download output, which is a directory when a
+// shared directory is downloaded, so that rerunning a
+// download only fetches the chunks that are still missing.
+type downloadState struct {
+	FileHash    string         `json:"file_hash"`
+	ChunkHashes map[int]string `json:"chunk_hashes"` // Chunk hashes from the file's manifest
+	Completed   map[int]int    `json:"completed"`    // Verified chunk index -> bytes written
+
+	path     string
+	mu       sync.Mutex
+	lastSave time.Time
+}
+
+// statePath returns the sidecar state file path for a download output path.
+func statePath(outputPath string) string {
+	return outputPath + ".state"
+}
+
+// loadDownloadState reads the sidecar state for outputPath. A missing, unreadable or
+// mismatched state file yields a fresh state, since the download can always start over.
+func loadDownloadState(outputPath, fileHash string) *downloadState {
+	state := &downloadState{
+		FileHash:    fileHash,
+		ChunkHashes: make(map[int]string),
+		Completed:   make(map[int]int),
+		path:        statePath(outputPath),
+	}
+
+	data, err := os.ReadFile(state.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: could not read download state %s: %v. Starting from scratch.", state.path, err)
+		}
+		return state
+	}
+
+	var saved downloadState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Warning: corrupt download state %s: %v. Starting from scratch.", state.path, err)
+		return state
+	}
+	if saved.FileHash != fileHash {
+		log.Printf("Warning: download state %s belongs to file %s. Starting from scratch.", state.path, saved.FileHash)
+		return state
+	}
+	if saved.ChunkHashes != nil {
+		state.ChunkHashes = saved.ChunkHashes
+	}
+	if saved.Completed != nil {
+		state.Completed = saved.Completed
+	}
+	return state
+}
+
+// reconcile updates the recorded chunk hashes from the manifest and re-verifies every
+// chunk marked complete against the data actually on disk. It returns the number of
+// chunks that can be kept.
+func (s *downloadState) reconcile(manifest *file.Manifest, alg *file.HashAlgorithm, outputPath string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for index, hash := range manifest.ChunkHashes {
+		if previous, ok := s.ChunkHashes[index]; ok && previous != hash {
+			// The tracker now reports a different hash, so whatever we wrote is stale.
+			delete(s.Completed, index)
+		}
+		s.ChunkHashes[index] = hash
+	}
+	for index := range s.ChunkHashes {
+		if index >= manifest.TotalChunks() {
+			delete(s.ChunkHashes, index)
+		}
+	}
+
+	for index, size := range s.Completed {
+		expectedChunkHash, ok := s.ChunkHashes[index]
+		if !ok {
+			delete(s.Completed, index)
+			continue
+		}
+		path, offset := chunkTarget(manifest, outputPath, index)
+		data, err := file.ReadChunkAtOffset(path, offset, size)
+		if err != nil || !file.VerifyChunk(alg, data, expectedChunkHash) {
+			log.Printf("Chunk %d on disk failed re-verification, it will be downloaded again.", index)
+			delete(s.Completed, index)
+		}
+	}
+	return len(s.Completed)
+}
+
+// isCompleted reports whether a chunk is already verified on disk.
+func (s *downloadState) isCompleted(index int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Completed[index]
+	return ok
+}
+
+// completedSize returns the number of bytes written for a verified chunk.
+func (s *downloadState) completedSize(index int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.Completed[index]
+}
+
+// markCompleted records a verified chunk, flushing to disk at most every stateSaveInterval.
+func (s *downloadState) markCompleted(index, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Completed[index] = size
+	if time.Since(s.lastSave) >= stateSaveInterval {
+		if err := s.saveLocked(); err != nil {
+			log.Printf("Warning: failed to save download state: %v", err)
+		}
+	}
+}
+
+// save flushes the state to its sidecar file.
+func (s *downloadState) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.saveLocked()
+}
+
+func (s *downloadState) saveLocked() error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so a crash never leaves a truncated state file behind.
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write download state: %v", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to write download state: %v", err)
+	}
+	s.lastSave = time.Now()
+	return nil
+}
+
+// remove deletes the sidecar file once the download is complete.
+func (s *downloadState) remove() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
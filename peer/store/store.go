@@ -0,0 +1,363 @@
+// Package store keeps verified chunks on disk, addressed by chunk ID (the chunk hash
+// formatted with file.HashAlgorithm.FormatID), so they can be served and reused no
+// matter which file they came from.
+//
+// Chunks are reference counted by the files that use them. Each file holding references
+// has a small ref file listing its chunks, and a chunk's count is the number of ref files
+// naming it. Ref files are written before any of their chunks, so garbage collection
+// never removes a chunk a download is still adding.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ShreyamKundu/peernet/peer/file"
+)
+
+// staleTempAge is how old a leftover temporary file must be before GC removes it.
+const staleTempAge = time.Hour
+
+// Store is a directory of chunks keyed by chunk ID. It is safe for use by several
+// processes at once, since every change is a single file creation, rename or removal.
+type Store struct {
+	dir string
+}
+
+// refFile is the on-disk list of chunks a file references.
+type refFile struct {
+	Chunks []string `json:"chunks"`
+	Output string   `json:"output,omitempty"` // Where the file was downloaded to, once it has been
+}
+
+// GCResult summarises a garbage collection run.
+type GCResult struct {
+	RemovedChunks int      // Chunk files deleted
+	FreedBytes    int64    // Bytes reclaimed
+	ReleasedFiles []string // Files whose references were dropped to get under the quota
+	RemovedFiles  []string // Files whose references were dropped because their download was deleted
+	TotalBytes    int64    // Bytes held by the store afterwards
+}
+
+// Open opens the store in dir, creating it if needed.
+func Open(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+	for _, sub := range []string{s.chunksDir(), s.refsDir()} {
+		if err := os.MkdirAll(sub, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create chunk store %s: %v", dir, err)
+		}
+	}
+	return s, nil
+}
+
+// Dir returns the store's directory.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) chunksDir() string { return filepath.Join(s.dir, "chunks") }
+func (s *Store) refsDir() string   { return filepath.Join(s.dir, "refs") }
+
+// validID rejects anything that is not a well-formed ID, since IDs come from the
+// network and end up in file paths.
+func validID(id string) error {
+	if _, _, err := file.ParseID(id); err != nil {
+		return fmt.Errorf("invalid chunk ID %q: %v", id, err)
+	}
+	return nil
+}
+
+// chunkPath returns where a chunk is stored. Chunks are spread over subdirectories
+// by the last two hex digits of their ID, which are uniformly distributed.
+func (s *Store) chunkPath(id string) string {
+	return filepath.Join(s.chunksDir(), id[len(id)-2:], id)
+}
+
+// Locate returns the path and size of a stored chunk, and whether it is present.
+func (s *Store) Locate(id string) (string, int64, bool) {
+	if validID(id) != nil {
+		return "", 0, false
+	}
+	path := s.chunkPath(id)
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, false
+	}
+	return path, info.Size(), true
+}
+
+// Read returns the contents of a stored chunk.
+func (s *Store) Read(id string) ([]byte, error) {
+	if err := validID(id); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(s.chunkPath(id))
+}
+
+// Put stores a chunk. The caller must already have verified data against id.
+// Storing a chunk that is already present only refreshes its modification time.
+func (s *Store) Put(id string, data []byte) error {
+	if err := validID(id); err != nil {
+		return err
+	}
+	path := s.chunkPath(id)
+	if _, err := os.Stat(path); err == nil {
+		// Refresh its time so a GC already in progress doesn't remove it.
+		now := time.Now()
+		os.Chtimes(path, now, now)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to store chunk %s: %v", id, err)
+	}
+	// Write to a temporary file first so a reader never sees a partial chunk.
+	tmp, err := os.CreateTemp(filepath.Dir(path), id+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to store chunk %s: %v", id, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store chunk %s: %v", id, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store chunk %s: %v", id, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store chunk %s: %v", id, err)
+	}
+	return nil
+}
+
+// AddRef records that the file identified by owner uses the given chunks, replacing
+// any references it held before. It also marks the file as recently used, which
+// decides the order GC releases files in when the store is over its quota.
+func (s *Store) AddRef(owner string, ids []string) error {
+	if err := validID(owner); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := validID(id); err != nil {
+			return err
+		}
+	}
+	return s.writeRef(owner, refFile{Chunks: ids})
+}
+
+// SetOutput records where the file identified by owner was downloaded to. Once that
+// path is gone, GC releases the file's references.
+func (s *Store) SetOutput(owner, output string) error {
+	if err := validID(owner); err != nil {
+		return err
+	}
+	output, err := filepath.Abs(output)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(filepath.Join(s.refsDir(), owner))
+	if err != nil {
+		return fmt.Errorf("failed to read chunk references of %s: %v", owner, err)
+	}
+	var ref refFile
+	if err := json.Unmarshal(data, &ref); err != nil {
+		return fmt.Errorf("failed to read chunk references of %s: %v", owner, err)
+	}
+	ref.Output = output
+	return s.writeRef(owner, ref)
+}
+
+func (s *Store) writeRef(owner string, ref refFile) error {
+	data, err := json.Marshal(ref)
+	if err != nil {
+		return err
+	}
+	// Each writer gets its own temporary file, so processes updating the same owner at
+	// once never rename each other's partial data into place.
+	tmp, err := os.CreateTemp(s.refsDir(), owner+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to record chunk references of %s: %v", owner, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to record chunk references of %s: %v", owner, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to record chunk references of %s: %v", owner, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(s.refsDir(), owner)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to record chunk references of %s: %v", owner, err)
+	}
+	return nil
+}
+
+// Release drops the references held by owner. Its chunks are removed by the next GC
+// unless another file still references them.
+func (s *Store) Release(owner string) error {
+	if err := validID(owner); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.refsDir(), owner)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// storedRef is a ref file loaded for GC.
+type storedRef struct {
+	owner   string
+	chunks  []string
+	output  string
+	modTime time.Time
+}
+
+// loadRefs reads every ref file, least recently used first. Temporary files left behind
+// by an interrupted writeRef are removed once they are stale.
+func (s *Store) loadRefs() ([]storedRef, error) {
+	entries, err := os.ReadDir(s.refsDir())
+	if err != nil {
+		return nil, err
+	}
+	var refs []storedRef
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // Released while we were reading
+		}
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			if time.Since(info.ModTime()) > staleTempAge {
+				os.Remove(filepath.Join(s.refsDir(), entry.Name()))
+			}
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.refsDir(), entry.Name()))
+		if err != nil {
+			continue
+		}
+		var ref refFile
+		if err := json.Unmarshal(data, &ref); err != nil {
+			log.Printf("Warning: ignoring corrupt chunk references %s: %v", entry.Name(), err)
+			continue
+		}
+		refs = append(refs, storedRef{owner: entry.Name(), chunks: ref.Chunks, output: ref.Output, modTime: info.ModTime()})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].modTime.Before(refs[j].modTime) })
+	return refs, nil
+}
+
+// GC releases files whose download has been deleted, then removes chunks that no file
+// references. If quota is positive and the store is still larger than quota, it then
+// releases files, least recently used first, and removes the chunks only they
+// referenced until the store fits.
+func (s *Store) GC(quota int64) (*GCResult, error) {
+	// Chunks written after the refs are read belong to refs this run hasn't seen.
+	start := time.Now()
+	loaded, err := s.loadRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chunk references: %v", err)
+	}
+	result := &GCResult{}
+	var refs []storedRef
+	for _, ref := range loaded {
+		if ref.output != "" {
+			if _, err := os.Stat(ref.output); os.IsNotExist(err) {
+				if err := s.Release(ref.owner); err != nil {
+					return result, err
+				}
+				result.RemovedFiles = append(result.RemovedFiles, ref.owner)
+				continue
+			}
+		}
+		refs = append(refs, ref)
+	}
+	counts := make(map[string]int)
+	for _, ref := range refs {
+		for _, id := range unique(ref.chunks) {
+			counts[id]++
+		}
+	}
+
+	sizes := make(map[string]int64)
+	err = filepath.WalkDir(s.chunksDir(), func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		name := entry.Name()
+		if strings.HasSuffix(name, ".tmp") {
+			// Left behind by an interrupted Put.
+			if time.Since(info.ModTime()) > staleTempAge && os.Remove(path) == nil {
+				result.FreedBytes += info.Size()
+			}
+			return nil
+		}
+		if counts[name] == 0 && info.ModTime().Before(start) {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			result.RemovedChunks++
+			result.FreedBytes += info.Size()
+			return nil
+		}
+		sizes[name] = info.Size()
+		result.TotalBytes += info.Size()
+		return nil
+	})
+	if err != nil {
+		return result, fmt.Errorf("failed to scan chunk store: %v", err)
+	}
+
+	for _, ref := range refs {
+		if quota <= 0 || result.TotalBytes <= quota {
+			break
+		}
+		if err := s.Release(ref.owner); err != nil {
+			return result, err
+		}
+		result.ReleasedFiles = append(result.ReleasedFiles, ref.owner)
+		for _, id := range unique(ref.chunks) {
+			counts[id]--
+			size, stored := sizes[id]
+			if counts[id] > 0 || !stored {
+				continue
+			}
+			if err := os.Remove(s.chunkPath(id)); err != nil && !os.IsNotExist(err) {
+				return result, err
+			}
+			delete(sizes, id)
+			result.RemovedChunks++
+			result.FreedBytes += size
+			result.TotalBytes -= size
+		}
+	}
+	return result, nil
+}
+
+// unique returns ids without duplicates, so a file using a chunk twice counts once.
+func unique(ids []string) []string {
+	seen := make(map[string]bool, len(ids))
+	out := ids[:0:0]
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	return out
+}
@@ -23,9 +23,12 @@ const (
 
 // The request message containing chunk details.
 type ChunkRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	FileHash      string                 `protobuf:"bytes,1,opt,name=file_hash,json=fileHash,proto3" json:"file_hash,omitempty"`
-	ChunkIndex    int32                  `protobuf:"varint,2,opt,name=chunk_index,json=chunkIndex,proto3" json:"chunk_index,omitempty"`
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	FileHash   string                 `protobuf:"bytes,1,opt,name=file_hash,json=fileHash,proto3" json:"file_hash,omitempty"`
+	ChunkIndex int32                  `protobuf:"varint,2,opt,name=chunk_index,json=chunkIndex,proto3" json:"chunk_index,omitempty"`
+	// ID of the chunk (its hash with the algorithm prefix of a file hash). Peers that
+	// don't share file_hash still serve the chunk if they hold it under any file.
+	ChunkId       string `protobuf:"bytes,3,opt,name=chunk_id,json=chunkId,proto3" json:"chunk_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -74,11 +77,20 @@ func (x *ChunkRequest) GetChunkIndex() int32 {
 	return 0
 }
 
+func (x *ChunkRequest) GetChunkId() string {
+	if x != nil {
+		return x.ChunkId
+	}
+	return ""
+}
+
 // The response message containing the chunk data.
 type ChunkResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// The raw (and encrypted) bytes of the file chunk.
-	ChunkData     []byte `protobuf:"bytes,1,opt,name=chunk_data,json=chunkData,proto3" json:"chunk_data,omitempty"`
+	// The raw bytes of the file chunk. They are encrypted in transit only when peers use TLS.
+	ChunkData []byte `protobuf:"bytes,1,opt,name=chunk_data,json=chunkData,proto3" json:"chunk_data,omitempty"`
+	// Merkle inclusion proof of the chunk: hex-encoded sibling hashes, leaf level first.
+	Proof         []string `protobuf:"bytes,2,rep,name=proof,proto3" json:"proof,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -120,20 +132,284 @@ func (x *ChunkResponse) GetChunkData() []byte {
 	return nil
 }
 
+func (x *ChunkResponse) GetProof() []string {
+	if x != nil {
+		return x.Proof
+	}
+	return nil
+}
+
+// Requests a byte range of a chunk. The range starts at offset within the chunk
+// and covers length bytes; a length of 0 means up to the end of the chunk.
+type ChunkRangeRequest struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	FileHash   string                 `protobuf:"bytes,1,opt,name=file_hash,json=fileHash,proto3" json:"file_hash,omitempty"`
+	ChunkIndex int32                  `protobuf:"varint,2,opt,name=chunk_index,json=chunkIndex,proto3" json:"chunk_index,omitempty"`
+	Offset     int64                  `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	Length     int64                  `protobuf:"varint,4,opt,name=length,proto3" json:"length,omitempty"`
+	// ID of the chunk, as in ChunkRequest.
+	ChunkId       string `protobuf:"bytes,5,opt,name=chunk_id,json=chunkId,proto3" json:"chunk_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChunkRangeRequest) Reset() {
+	*x = ChunkRangeRequest{}
+	mi := &file_proto_peernet_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChunkRangeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChunkRangeRequest) ProtoMessage() {}
+
+func (x *ChunkRangeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_peernet_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChunkRangeRequest.ProtoReflect.Descriptor instead.
+func (*ChunkRangeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_peernet_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *ChunkRangeRequest) GetFileHash() string {
+	if x != nil {
+		return x.FileHash
+	}
+	return ""
+}
+
+func (x *ChunkRangeRequest) GetChunkIndex() int32 {
+	if x != nil {
+		return x.ChunkIndex
+	}
+	return 0
+}
+
+func (x *ChunkRangeRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ChunkRangeRequest) GetLength() int64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *ChunkRangeRequest) GetChunkId() string {
+	if x != nil {
+		return x.ChunkId
+	}
+	return ""
+}
+
+// One block of a streamed chunk.
+type ChunkBlock struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Position of this block within the chunk.
+	Offset int64 `protobuf:"varint,1,opt,name=offset,proto3" json:"offset,omitempty"`
+	// The raw bytes of the block.
+	Data []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+	// Total size of the chunk, so the receiver knows when it has all of it.
+	ChunkSize int64 `protobuf:"varint,3,opt,name=chunk_size,json=chunkSize,proto3" json:"chunk_size,omitempty"`
+	// Merkle inclusion proof of the chunk, sent with the first block of a stream.
+	Proof         []string `protobuf:"bytes,4,rep,name=proof,proto3" json:"proof,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ChunkBlock) Reset() {
+	*x = ChunkBlock{}
+	mi := &file_proto_peernet_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ChunkBlock) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChunkBlock) ProtoMessage() {}
+
+func (x *ChunkBlock) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_peernet_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChunkBlock.ProtoReflect.Descriptor instead.
+func (*ChunkBlock) Descriptor() ([]byte, []int) {
+	return file_proto_peernet_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ChunkBlock) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *ChunkBlock) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *ChunkBlock) GetChunkSize() int64 {
+	if x != nil {
+		return x.ChunkSize
+	}
+	return 0
+}
+
+func (x *ChunkBlock) GetProof() []string {
+	if x != nil {
+		return x.Proof
+	}
+	return nil
+}
+
+// Sent by the tracker to check that a peer is reachable.
+type PingRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingRequest) Reset() {
+	*x = PingRequest{}
+	mi := &file_proto_peernet_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingRequest) ProtoMessage() {}
+
+func (x *PingRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_peernet_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingRequest.ProtoReflect.Descriptor instead.
+func (*PingRequest) Descriptor() ([]byte, []int) {
+	return file_proto_peernet_proto_rawDescGZIP(), []int{4}
+}
+
+// The answer to a ping.
+type PingResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// ID of the peer answering, so the tracker can tell it reached the peer it meant to.
+	// Empty if the peer doesn't know its ID.
+	PeerId        string `protobuf:"bytes,1,opt,name=peer_id,json=peerId,proto3" json:"peer_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PingResponse) Reset() {
+	*x = PingResponse{}
+	mi := &file_proto_peernet_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PingResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PingResponse) ProtoMessage() {}
+
+func (x *PingResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_peernet_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PingResponse.ProtoReflect.Descriptor instead.
+func (*PingResponse) Descriptor() ([]byte, []int) {
+	return file_proto_peernet_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *PingResponse) GetPeerId() string {
+	if x != nil {
+		return x.PeerId
+	}
+	return ""
+}
+
 var File_proto_peernet_proto protoreflect.FileDescriptor
 
 const file_proto_peernet_proto_rawDesc = "" +
 	"\n" +
-	"\x13proto/peernet.proto\x12\x05proto\"L\n" +
+	"\x13proto/peernet.proto\x12\x05proto\"g\n" +
 	"\fChunkRequest\x12\x1b\n" +
 	"\tfile_hash\x18\x01 \x01(\tR\bfileHash\x12\x1f\n" +
 	"\vchunk_index\x18\x02 \x01(\x05R\n" +
-	"chunkIndex\".\n" +
+	"chunkIndex\x12\x19\n" +
+	"\bchunk_id\x18\x03 \x01(\tR\achunkId\"D\n" +
 	"\rChunkResponse\x12\x1d\n" +
 	"\n" +
-	"chunk_data\x18\x01 \x01(\fR\tchunkData2I\n" +
+	"chunk_data\x18\x01 \x01(\fR\tchunkData\x12\x14\n" +
+	"\x05proof\x18\x02 \x03(\tR\x05proof\"\x9c\x01\n" +
+	"\x11ChunkRangeRequest\x12\x1b\n" +
+	"\tfile_hash\x18\x01 \x01(\tR\bfileHash\x12\x1f\n" +
+	"\vchunk_index\x18\x02 \x01(\x05R\n" +
+	"chunkIndex\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x03R\x06offset\x12\x16\n" +
+	"\x06length\x18\x04 \x01(\x03R\x06length\x12\x19\n" +
+	"\bchunk_id\x18\x05 \x01(\tR\achunkId\"m\n" +
+	"\n" +
+	"ChunkBlock\x12\x16\n" +
+	"\x06offset\x18\x01 \x01(\x03R\x06offset\x12\x12\n" +
+	"\x04data\x18\x02 \x01(\fR\x04data\x12\x1d\n" +
+	"\n" +
+	"chunk_size\x18\x03 \x01(\x03R\tchunkSize\x12\x14\n" +
+	"\x05proof\x18\x04 \x03(\tR\x05proof\"\r\n" +
+	"\vPingRequest\"'\n" +
+	"\fPingResponse\x12\x17\n" +
+	"\apeer_id\x18\x01 \x01(\tR\x06peerId2\xb8\x01\n" +
 	"\vPeerService\x12:\n" +
-	"\rDownloadChunk\x12\x13.proto.ChunkRequest\x1a\x14.proto.ChunkResponseB'Z%github.com/ShreyamKundu/peernet/protob\x06proto3"
+	"\rDownloadChunk\x12\x13.proto.ChunkRequest\x1a\x14.proto.ChunkResponse\x12<\n" +
+	"\vStreamChunk\x12\x18.proto.ChunkRangeRequest\x1a\x11.proto.ChunkBlock0\x01\x12/\n" +
+	"\x04Ping\x12\x12.proto.PingRequest\x1a\x13.proto.PingResponseB'Z%github.com/ShreyamKundu/peernet/protob\x06proto3"
 
 var (
 	file_proto_peernet_proto_rawDescOnce sync.Once
@@ -147,16 +423,24 @@ func file_proto_peernet_proto_rawDescGZIP() []byte {
 	return file_proto_peernet_proto_rawDescData
 }
 
-var file_proto_peernet_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_proto_peernet_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
 var file_proto_peernet_proto_goTypes = []any{
-	(*ChunkRequest)(nil),  // 0: proto.ChunkRequest
-	(*ChunkResponse)(nil), // 1: proto.ChunkResponse
+	(*ChunkRequest)(nil),      // 0: proto.ChunkRequest
+	(*ChunkResponse)(nil),     // 1: proto.ChunkResponse
+	(*ChunkRangeRequest)(nil), // 2: proto.ChunkRangeRequest
+	(*ChunkBlock)(nil),        // 3: proto.ChunkBlock
+	(*PingRequest)(nil),       // 4: proto.PingRequest
+	(*PingResponse)(nil),      // 5: proto.PingResponse
 }
 var file_proto_peernet_proto_depIdxs = []int32{
 	0, // 0: proto.PeerService.DownloadChunk:input_type -> proto.ChunkRequest
-	1, // 1: proto.PeerService.DownloadChunk:output_type -> proto.ChunkResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
+	2, // 1: proto.PeerService.StreamChunk:input_type -> proto.ChunkRangeRequest
+	4, // 2: proto.PeerService.Ping:input_type -> proto.PingRequest
+	1, // 3: proto.PeerService.DownloadChunk:output_type -> proto.ChunkResponse
+	3, // 4: proto.PeerService.StreamChunk:output_type -> proto.ChunkBlock
+	5, // 5: proto.PeerService.Ping:output_type -> proto.PingResponse
+	3, // [3:6] is the sub-list for method output_type
+	0, // [0:3] is the sub-list for method input_type
 	0, // [0:0] is the sub-list for extension type_name
 	0, // [0:0] is the sub-list for extension extendee
 	0, // [0:0] is the sub-list for field type_name
@@ -173,7 +457,7 @@ func file_proto_peernet_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_peernet_proto_rawDesc), len(file_proto_peernet_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   6,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
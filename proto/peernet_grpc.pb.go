@@ -20,6 +20,8 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	PeerService_DownloadChunk_FullMethodName = "/proto.PeerService/DownloadChunk"
+	PeerService_StreamChunk_FullMethodName   = "/proto.PeerService/StreamChunk"
+	PeerService_Ping_FullMethodName          = "/proto.PeerService/Ping"
 )
 
 // PeerServiceClient is the client API for PeerService service.
@@ -30,6 +32,10 @@ const (
 type PeerServiceClient interface {
 	// Requests a file chunk from a peer.
 	DownloadChunk(ctx context.Context, in *ChunkRequest, opts ...grpc.CallOption) (*ChunkResponse, error)
+	// Streams a file chunk, or a byte range of it, as a sequence of blocks.
+	StreamChunk(ctx context.Context, in *ChunkRangeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChunkBlock], error)
+	// Answers the tracker checking that the peer is reachable at its registered address.
+	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error)
 }
 
 type peerServiceClient struct {
@@ -50,6 +56,35 @@ func (c *peerServiceClient) DownloadChunk(ctx context.Context, in *ChunkRequest,
 	return out, nil
 }
 
+func (c *peerServiceClient) StreamChunk(ctx context.Context, in *ChunkRangeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[ChunkBlock], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &PeerService_ServiceDesc.Streams[0], PeerService_StreamChunk_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ChunkRangeRequest, ChunkBlock]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PeerService_StreamChunkClient = grpc.ServerStreamingClient[ChunkBlock]
+
+func (c *peerServiceClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PingResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PingResponse)
+	err := c.cc.Invoke(ctx, PeerService_Ping_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // PeerServiceServer is the server API for PeerService service.
 // All implementations must embed UnimplementedPeerServiceServer
 // for forward compatibility.
@@ -58,6 +93,10 @@ func (c *peerServiceClient) DownloadChunk(ctx context.Context, in *ChunkRequest,
 type PeerServiceServer interface {
 	// Requests a file chunk from a peer.
 	DownloadChunk(context.Context, *ChunkRequest) (*ChunkResponse, error)
+	// Streams a file chunk, or a byte range of it, as a sequence of blocks.
+	StreamChunk(*ChunkRangeRequest, grpc.ServerStreamingServer[ChunkBlock]) error
+	// Answers the tracker checking that the peer is reachable at its registered address.
+	Ping(context.Context, *PingRequest) (*PingResponse, error)
 	mustEmbedUnimplementedPeerServiceServer()
 }
 
@@ -71,6 +110,12 @@ type UnimplementedPeerServiceServer struct{}
 func (UnimplementedPeerServiceServer) DownloadChunk(context.Context, *ChunkRequest) (*ChunkResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DownloadChunk not implemented")
 }
+func (UnimplementedPeerServiceServer) StreamChunk(*ChunkRangeRequest, grpc.ServerStreamingServer[ChunkBlock]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamChunk not implemented")
+}
+func (UnimplementedPeerServiceServer) Ping(context.Context, *PingRequest) (*PingResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
+}
 func (UnimplementedPeerServiceServer) mustEmbedUnimplementedPeerServiceServer() {}
 func (UnimplementedPeerServiceServer) testEmbeddedByValue()                     {}
 
@@ -110,6 +155,35 @@ func _PeerService_DownloadChunk_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _PeerService_StreamChunk_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ChunkRangeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PeerServiceServer).StreamChunk(m, &grpc.GenericServerStream[ChunkRangeRequest, ChunkBlock]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type PeerService_StreamChunkServer = grpc.ServerStreamingServer[ChunkBlock]
+
+func _PeerService_Ping_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PingRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerServiceServer).Ping(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: PeerService_Ping_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerServiceServer).Ping(ctx, req.(*PingRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // PeerService_ServiceDesc is the grpc.ServiceDesc for PeerService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -121,7 +195,17 @@ var PeerService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DownloadChunk",
 			Handler:    _PeerService_DownloadChunk_Handler,
 		},
+		{
+			MethodName: "Ping",
+			Handler:    _PeerService_Ping_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamChunk",
+			Handler:       _PeerService_StreamChunk_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "proto/peernet.proto",
 }
@@ -18,6 +18,7 @@ echo ""
 echo "--- Preparing sample file directories for our peers... ---"
 mkdir -p shared_files/peer1/data
 mkdir -p shared_files/peer2/downloads
+rm -f shared_files/peer2/downloads/sample*.txt # Downloads never overwrite, so clear the previous run's copy
 echo "Directory 'shared_files/peer1/data' created for Peer 1 to share files from."
 echo "Directory 'shared_files/peer2/downloads' created for Peer 2 to download files into."
 
@@ -77,7 +78,8 @@ sleep 2 # Give tracker a moment to update its records after registrations
 echo "✅ Tracker state updated."
 echo ""
 
-# Define a temporary log file path inside the peer1 container
+# Define temporary log file paths inside the peer1 container
+PEER1_SERVE_LOG="/tmp/peer1_serve.log"
 PEER1_SHARE_LOG="/tmp/peer1_share.log"
 
 # --- Section 5: Peer 1 Shares File ---
@@ -85,9 +87,16 @@ echo "████████████████████████
 echo "███ PHASE 4: FILE SHARING FROM PEER 1 ███"
 echo "████████████████████████████████████████████████████████████████████████████████"
 echo ""
-echo "--- Instructing Peer 1 to start its gRPC server and announce the sample file to the tracker. This runs in the background. ---"
+echo "--- Starting Peer 1's seeding daemon. It serves every shared file on a single gRPC port and runs in the background. ---"
+echo "--- The daemon's output will be logged to $PEER1_SERVE_LOG inside its container. ---"
+docker exec peernet-peer1 sh -c "./peer-client serve --port \"$PEER1_GRPC_PORT\" > $PEER1_SERVE_LOG 2>&1 &"
+sleep 2
+echo "✅ Peer 1's seeding daemon is running."
+echo ""
+
+echo "--- Instructing Peer 1's daemon to share the sample file and announce it to the tracker. ---"
 echo "--- Peer 1's output for this operation will be logged to $PEER1_SHARE_LOG inside its container. ---"
-docker exec peernet-peer1 sh -c "./peer-client share /home/appuser/data/sample.txt --port \"$PEER1_GRPC_PORT\" > $PEER1_SHARE_LOG 2>&1 &"
+docker exec peernet-peer1 sh -c "./peer-client share /home/appuser/data/sample.txt > $PEER1_SHARE_LOG 2>&1"
 
 echo "Share command initiated. Now, we wait for Peer 1 to process the file, calculate its unique hash, and log it. This hash is crucial for Peer 2 to find the file."
 
@@ -117,7 +126,7 @@ echo "✅ File Hash found: $FILE_HASH. This is the unique identifier for our sha
 echo ""
 
 DOWNLOAD_OUTPUT_DIR="/home/appuser/downloads"
-LOCAL_DOWNLOADED_FILE_PATH="shared_files/peer2/downloads/${FILE_HASH}.download"
+LOCAL_DOWNLOADED_FILE_PATH="shared_files/peer2/downloads/sample.txt" # Verified downloads keep their original name
 
 # --- Section 6: Peer 2 Downloads File ---
 echo "████████████████████████████████████████████████████████████████████████████████"
@@ -159,9 +168,9 @@ echo "████████████████████████
 echo "███ PHASE 7: FINAL CLEANUP ███"
 echo "████████████████████████████████████████████████████████████████████████████████"
 echo ""
-echo "--- Cleaning up the temporary log file created in Peer 1's container... ---"
-docker exec peernet-peer1 rm -f "$PEER1_SHARE_LOG"
-echo "✅ Temporary log file cleaned up."
+echo "--- Cleaning up the temporary log files created in Peer 1's container... ---"
+docker exec peernet-peer1 rm -f "$PEER1_SHARE_LOG" "$PEER1_SERVE_LOG"
+echo "✅ Temporary log files cleaned up."
 echo ""
 
 echo "████████████████████████████████████████████████████████████████████████████████"
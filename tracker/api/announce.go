@@ -0,0 +1,173 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// batchAnnouncementRequest announces many chunks of one file at once. A peer holding a
+// whole file sends its manifest, which is published if it isn't already. A peer holding
+// only some chunks of a file whose manifest is published sends a bitfield instead: bit i,
+// counting from the most significant bit of the first byte, is set if it holds chunk i.
+// With no bitfield every chunk is announced.
+type batchAnnouncementRequest struct {
+	FileHash string        `json:"file_hash" binding:"required"`
+	Manifest *fileManifest `json:"manifest,omitempty"`
+	Bitfield string        `json:"bitfield,omitempty"` // Base64-encoded
+}
+
+// heldChunks decodes a bitfield of totalChunks bits into the indices of the set bits.
+func heldChunks(bitfield string, totalChunks int) ([]int64, error) {
+	bits, err := base64.StdEncoding.DecodeString(bitfield)
+	if err != nil {
+		return nil, fmt.Errorf("bitfield is not valid base64: %v", err)
+	}
+	if len(bits) != (totalChunks+7)/8 {
+		return nil, fmt.Errorf("bitfield has %d bytes, expected %d for %d chunks", len(bits), (totalChunks+7)/8, totalChunks)
+	}
+	var held []int64
+	for i := 0; i < len(bits)*8; i++ {
+		if bits[i/8]&(0x80>>(i%8)) == 0 {
+			continue
+		}
+		if i >= totalChunks {
+			return nil, fmt.Errorf("bitfield sets bit %d, but the file has %d chunks", i, totalChunks)
+		}
+		held = append(held, int64(i))
+	}
+	return held, nil
+}
+
+// announceBatch records that the peer holds a set of chunks of a file, publishing the
+// file's manifest first if one is included, all in a single transaction. Chunk hashes
+// come from the published manifest, so it must exist by the time chunks are announced.
+func announceBatch(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req batchAnnouncementRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if req.Manifest != nil {
+			if req.Manifest.FileHash != req.FileHash {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "manifest is for a different file_hash"})
+				return
+			}
+			if err := req.Manifest.validate(); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		peerID, _ := c.Get("peerID")
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback() // Rollback on error
+
+		if req.Manifest != nil {
+			_, err := insertManifest(tx, req.Manifest)
+			if err == errManifestConflict {
+				c.JSON(http.StatusConflict, gin.H{"error": "A different manifest is already published for this file hash"})
+				return
+			}
+			if err != nil {
+				log.Printf("Failed to publish manifest: %v", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish manifest"})
+				return
+			}
+		}
+
+		var totalChunks int
+		err = tx.QueryRow(`SELECT total_chunks FROM files WHERE file_hash = $1 AND file_size IS NOT NULL;`, req.FileHash).Scan(&totalChunks)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "File has no published manifest; include one in the request"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		// NULL selects every chunk.
+		var held any
+		if req.Bitfield != "" {
+			indices, err := heldChunks(req.Bitfield, totalChunks)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			held = pq.Array(indices)
+		}
+
+		result, err := tx.Exec(`
+            INSERT INTO file_chunk_peers (file_hash, chunk_index, peer_id, chunk_hash)
+            SELECT fc.file_hash, fc.chunk_index, $2::uuid, fc.chunk_hash
+            FROM file_chunks fc
+            WHERE fc.file_hash = $1 AND ($3::int[] IS NULL OR fc.chunk_index = ANY($3::int[]))
+            ON CONFLICT DO NOTHING;`, req.FileHash, peerID.(string), held)
+		if err != nil {
+			log.Printf("Failed to announce chunks of %s: %v", req.FileHash, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to announce chunks"})
+			return
+		}
+		added, _ := result.RowsAffected() // Chunks already announced by this peer are not counted
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"status": "announced", "added_chunks": added})
+	}
+}
+
+type unannouncementRequest struct {
+	FileHash string `json:"file_hash" binding:"required"`
+}
+
+// unannounceFile withdraws every chunk of a file the peer announced, so lookups stop
+// returning it as a source.
+func unannounceFile(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req unannouncementRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		peerID, _ := c.Get("peerID")
+
+		result, err := db.Exec(`DELETE FROM file_chunk_peers WHERE file_hash = $1 AND peer_id = $2;`, req.FileHash, peerID.(string))
+		if err != nil {
+			log.Printf("Failed to unannounce %s: %v", req.FileHash, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unannounce file"})
+			return
+		}
+		removed, _ := result.RowsAffected()
+		c.JSON(http.StatusOK, gin.H{"status": "unannounced", "removed_chunks": removed})
+	}
+}
+
+// unannounceAll withdraws every chunk the peer announced, of every file.
+func unannounceAll(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		peerID, _ := c.Get("peerID")
+
+		result, err := db.Exec(`DELETE FROM file_chunk_peers WHERE peer_id = $1;`, peerID.(string))
+		if err != nil {
+			log.Printf("Failed to unannounce all files of peer %s: %v", peerID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unannounce files"})
+			return
+		}
+		removed, _ := result.RowsAffected()
+		c.JSON(http.StatusOK, gin.H{"status": "unannounced", "removed_chunks": removed})
+	}
+}
@@ -2,12 +2,16 @@ package api
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/ShreyamKundu/peernet/tracker/config"
+	"github.com/ShreyamKundu/peernet/tracker/reachability"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/ShreyamKundu/peernet/tracker/auth"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -31,22 +35,31 @@ type feedbackRequest struct {
 	EventType    string `json:"event_type" binding:"required"` // e.g., 'SUCCESS_UPLOAD', 'FAILED_UPLOAD'
 }
 
-// RegisterRoutes registers all API routes.
-func RegisterRoutes(router *gin.RouterGroup, db *sql.DB, jwtSecret string) {
-	// Public route
-	router.POST("/peers/register", registerPeer(db, jwtSecret))
+// RegisterRoutes registers all API routes. Peers that send no heartbeat for
+// cfg.PeerOfflineAfter are marked offline, and prober checks that new peers are reachable.
+func RegisterRoutes(router *gin.RouterGroup, db *sql.DB, cfg *config.Config, prober *reachability.Prober) {
+	// Public routes
+	router.POST("/peers/register", registerPeer(db, cfg.JWTSecret, cfg.RefreshTokenTTL, prober))
+	router.POST("/peers/login", login(db, cfg.JWTSecret, cfg.RefreshTokenTTL))
+	router.POST("/peers/token/refresh", refreshTokens(db, cfg.JWTSecret, cfg.RefreshTokenTTL))
 
 	// Authenticated routes
 	authed := router.Group("/")
-	authed.Use(AuthMiddleware(jwtSecret))
+	authed.Use(AuthMiddleware(cfg.JWTSecret))
 	{
+		authed.POST("/files/manifest", publishManifest(db))
 		authed.POST("/files/announce", announceFile(db))
+		authed.POST("/files/announce/batch", announceBatch(db))
+		authed.POST("/files/unannounce", unannounceFile(db))
+		authed.POST("/files/unannounce/all", unannounceAll(db))
 		authed.GET("/files/lookup/:fileHash", lookupFile(db))
 		authed.POST("/peers/feedback", submitFeedback(db))
+		authed.POST("/peers/heartbeat", heartbeat(db, cfg.PeerOfflineAfter, prober))
+		authed.POST("/peers/password", changePassword(db, cfg.JWTSecret, cfg.RefreshTokenTTL))
 	}
 }
 
-func registerPeer(db *sql.DB, jwtSecret string) gin.HandlerFunc {
+func registerPeer(db *sql.DB, jwtSecret string, refreshTTL time.Duration, prober *reachability.Prober) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req peerRegistrationRequest
 		if err := c.ShouldBindJSON(&req); err != nil {
@@ -68,13 +81,17 @@ func registerPeer(db *sql.DB, jwtSecret string) gin.HandlerFunc {
 			return
 		}
 
-		token, err := auth.GenerateToken(peerID.String(), jwtSecret)
+		// Dial the peer back in the background; it may not be serving yet.
+		prober.Check(peerID.String(), req.Address)
+
+		token, refreshToken, err := issueTokens(db, peerID.String(), "", jwtSecret, refreshTTL)
 		if err != nil {
+			log.Printf("Failed to issue tokens: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
 			return
 		}
 
-		c.JSON(http.StatusCreated, gin.H{"peer_id": peerID, "token": token})
+		c.JSON(http.StatusCreated, gin.H{"peer_id": peerID, "token": token, "refresh_token": refreshToken})
 	}
 }
 
@@ -85,6 +102,10 @@ func announceFile(db *sql.DB) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if _, ok := hashAlgorithmOf(req.FileHash); !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "file_hash does not use a supported hash algorithm"})
+			return
+		}
 		peerID, _ := c.Get("peerID")
 
 		// Use a transaction
@@ -104,6 +125,18 @@ func announceFile(db *sql.DB) gin.HandlerFunc {
 			return
 		}
 
+		// Once a manifest is published, it is the authority on chunk hashes.
+		var manifestChunkHash string
+		err = tx.QueryRow(`SELECT chunk_hash FROM file_chunks WHERE file_hash = $1 AND chunk_index = $2;`, req.FileHash, req.ChunkIndex).Scan(&manifestChunkHash)
+		if err == nil && manifestChunkHash != req.ChunkHash {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Chunk hash does not match the file's manifest"})
+			return
+		}
+		if err != nil && err != sql.ErrNoRows {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
 		// Insert chunk-peer mapping with chunk_hash
 		_, err = tx.Exec(`
             INSERT INTO file_chunk_peers (file_hash, chunk_index, peer_id, chunk_hash)
@@ -127,22 +160,28 @@ type PeerInfo struct {
 	ID              string  `json:"id"`
 	Address         string  `json:"address"`
 	ReputationScore float64 `json:"reputation_score"`
+	// Set when the peer holds an identical chunk as part of another file, which is
+	// the file and chunk index it must be asked for.
+	FileHash   string `json:"file_hash,omitempty"`
+	ChunkIndex int    `json:"chunk_index,omitempty"`
 }
 
 // ChunkLookupInfo holds information for a specific chunk, including its hash and available peers.
 type ChunkLookupInfo struct {
 	ChunkHash string     `json:"chunk_hash"`
 	Peers     []PeerInfo `json:"peers"`
+	// Peers the tracker failed to reach at their registered address when it last checked.
+	UnreachablePeers []PeerInfo `json:"unreachable_peers,omitempty"`
 }
 
 func lookupFile(db *sql.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		fileHash := c.Param("fileHash")
 		rows, err := db.Query(`
-            SELECT p.id, p.address, fcp.chunk_index, p.reputation_score, fcp.chunk_hash -- ADDED fcp.chunk_hash
+            SELECT p.id, p.address, fcp.chunk_index, p.reputation_score, fcp.chunk_hash, p.reachable IS NOT FALSE -- ADDED fcp.chunk_hash
             FROM file_chunk_peers fcp
             JOIN peers p ON fcp.peer_id = p.id
-            WHERE fcp.file_hash = $1
+            WHERE fcp.file_hash = $1 AND p.online
             ORDER BY fcp.chunk_index ASC, p.reputation_score DESC, p.last_seen DESC; -- Order by chunk_index first for consistency
         `, fileHash)
 		if err != nil {
@@ -158,7 +197,9 @@ func lookupFile(db *sql.DB) gin.HandlerFunc {
 			var peerID, address, chunkHash string // ADDED chunkHash
 			var chunkIndex int
 			var reputationScore float64
-			if err := rows.Scan(&peerID, &address, &chunkIndex, &reputationScore, &chunkHash); err != nil { // ADDED &chunkHash
+			// Peers not checked yet count as reachable.
+			var reachable bool
+			if err := rows.Scan(&peerID, &address, &chunkIndex, &reputationScore, &chunkHash, &reachable); err != nil { // ADDED &chunkHash
 				log.Printf("Error scanning lookup row: %v", err)
 				continue
 			}
@@ -169,11 +210,91 @@ func lookupFile(db *sql.DB) gin.HandlerFunc {
 				chunkInfo.Peers = make([]PeerInfo, 0)
 				chunkInfo.ChunkHash = chunkHash // Set the chunk hash for this chunk index
 			}
-			chunkInfo.Peers = append(chunkInfo.Peers, PeerInfo{ID: peerID, Address: address, ReputationScore: reputationScore})
+			peer := PeerInfo{ID: peerID, Address: address, ReputationScore: reputationScore}
+			if reachable {
+				chunkInfo.Peers = append(chunkInfo.Peers, peer)
+			} else {
+				chunkInfo.UnreachablePeers = append(chunkInfo.UnreachablePeers, peer)
+			}
+			chunkPeers[chunkIndex] = chunkInfo
+		}
+
+		// Peers that hold an identical chunk as part of another file can serve it too.
+		// Chunk hashes come from the manifest, so this only covers files that have one.
+		otherRows, err := db.Query(`
+            SELECT DISTINCT ON (fc.chunk_index, p.id)
+                p.id, p.address, fc.chunk_index, p.reputation_score, fc.chunk_hash, other.file_hash, other.chunk_index, p.reachable IS NOT FALSE
+            FROM file_chunks fc
+            JOIN file_chunk_peers other ON other.chunk_hash = fc.chunk_hash AND other.file_hash <> fc.file_hash
+            JOIN peers p ON other.peer_id = p.id
+            WHERE fc.file_hash = $1 AND p.online
+              AND NOT EXISTS (
+                  SELECT 1 FROM file_chunk_peers own
+                  WHERE own.file_hash = fc.file_hash AND own.chunk_index = fc.chunk_index AND own.peer_id = other.peer_id)
+            ORDER BY fc.chunk_index ASC, p.id, other.file_hash;
+        `, fileHash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database query failed"})
+			return
+		}
+		defer otherRows.Close()
+		for otherRows.Next() {
+			var peer PeerInfo
+			var chunkIndex int
+			var chunkHash string
+			var reachable bool
+			if err := otherRows.Scan(&peer.ID, &peer.Address, &chunkIndex, &peer.ReputationScore, &chunkHash, &peer.FileHash, &peer.ChunkIndex, &reachable); err != nil {
+				log.Printf("Error scanning lookup row: %v", err)
+				continue
+			}
+			chunkInfo := chunkPeers[chunkIndex]
+			if chunkInfo.Peers == nil {
+				chunkInfo.Peers = make([]PeerInfo, 0)
+				chunkInfo.ChunkHash = chunkHash
+			}
+			if reachable {
+				chunkInfo.Peers = append(chunkInfo.Peers, peer)
+			} else {
+				chunkInfo.UnreachablePeers = append(chunkInfo.UnreachablePeers, peer)
+			}
 			chunkPeers[chunkIndex] = chunkInfo
 		}
 
-		c.JSON(http.StatusOK, gin.H{"chunks": chunkPeers})
+		response := gin.H{"chunks": chunkPeers}
+		var fileName string
+		if err := db.QueryRow(`SELECT file_name FROM files WHERE file_hash = $1;`, fileHash).Scan(&fileName); err == nil {
+			response["file_name"] = fileName
+		}
+		hashAlgorithm, _ := hashAlgorithmOf(fileHash)
+		manifest, err := loadManifest(db, fileHash)
+		if err != nil {
+			log.Printf("Failed to load manifest of %s: %v", fileHash, err)
+		} else if manifest != nil {
+			response["manifest"] = manifest
+			hashAlgorithm = manifest.HashAlgorithm
+		}
+		if hashAlgorithm != "" {
+			response["hash_algorithm"] = hashAlgorithm
+		}
+
+		// Peers list the algorithms they can verify; tell them up front if this file needs another one.
+		if accepted := c.Query("hash_algorithms"); accepted != "" && hashAlgorithm != "" {
+			supported := false
+			for _, name := range strings.Split(accepted, ",") {
+				if strings.TrimSpace(name) == hashAlgorithm {
+					supported = true
+					break
+				}
+			}
+			if !supported {
+				c.JSON(http.StatusNotAcceptable, gin.H{
+					"error":          fmt.Sprintf("File is hashed with %s, which the peer does not support", hashAlgorithm),
+					"hash_algorithm": hashAlgorithm,
+				})
+				return
+			}
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
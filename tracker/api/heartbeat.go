@@ -0,0 +1,57 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ShreyamKundu/peernet/tracker/reachability"
+	"github.com/gin-gonic/gin"
+)
+
+// recheckAfter is how long after a failed reachability check a heartbeat triggers another,
+// so a peer that registered before it started serving doesn't wait for the next round.
+const recheckAfter = time.Minute
+
+// heartbeat records that the peer is alive. The response tells the peer how long the
+// tracker waits for the next heartbeat before marking it offline, and whether it had
+// been marked offline, in which case its announcements may have been purged and it
+// should announce its files again. Peers the tracker could not reach are checked again.
+func heartbeat(db *sql.DB, offlineAfter time.Duration, prober *reachability.Prober) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		peerID, _ := c.Get("peerID")
+
+		var wasOffline, recheck bool
+		var address string
+		err := db.QueryRow(`
+            WITH previous AS (SELECT online FROM peers WHERE id = $1 FOR UPDATE)
+            UPDATE peers SET last_seen = NOW(), online = TRUE
+            FROM previous
+            WHERE peers.id = $1
+            RETURNING NOT previous.online, peers.address,
+                peers.reachable IS FALSE AND peers.reachability_checked_at < NOW() - $2 * INTERVAL '1 second';`,
+			peerID.(string), recheckAfter.Seconds()).Scan(&wasOffline, &address, &recheck)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Peer is not registered"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to record heartbeat of peer %s: %v", peerID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record heartbeat"})
+			return
+		}
+		if wasOffline {
+			log.Printf("Peer %s is back online", peerID)
+		}
+		if recheck {
+			prober.Check(peerID.(string), address)
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":                "alive",
+			"offline_after_seconds": int(offlineAfter.Seconds()),
+			"was_offline":           wasOffline,
+		})
+	}
+}
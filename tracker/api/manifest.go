@@ -0,0 +1,397 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// supportedHashAlgorithms maps the hash algorithms a manifest may use to the hex prefix
+// of file hashes made with them: the multihash code and digest length as varints.
+var supportedHashAlgorithms = map[string]string{
+	"sha256":     "1220",
+	"blake3":     "1e20",
+	"sha512-256": "952020",
+}
+
+// legacyHashLength is the length of a bare hex SHA-256 file hash, as used before file
+// hashes carried their algorithm.
+const legacyHashLength = 64
+
+// hashAlgorithmOf returns the algorithm a file hash was made with.
+func hashAlgorithmOf(fileHash string) (string, bool) {
+	if len(fileHash) == legacyHashLength {
+		return "sha256", true
+	}
+	for name, prefix := range supportedHashAlgorithms {
+		if strings.HasPrefix(fileHash, prefix) && len(fileHash) == len(prefix)+legacyHashLength {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// contentDefinedChunking names variable-size chunks, whose sizes the manifest lists.
+// An empty chunking means fixed-size chunks.
+const contentDefinedChunking = "fastcdc"
+
+// manifestFile is one file of a shared directory: its path relative to the directory
+// and the range of the directory's chunks holding its data.
+type manifestFile struct {
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	FirstChunk int    `json:"first_chunk"`
+	Chunks     int    `json:"chunks"`
+}
+
+// fileManifest describes a file's exact size, chunk layout and chunk hashes.
+// It is published once per file and returned by lookups. With content-defined chunking,
+// chunk_size is the largest a chunk may be and chunk_sizes lists every chunk's size.
+// A shared directory's manifest also lists its files, and always has chunk_sizes.
+type fileManifest struct {
+	FileHash      string   `json:"file_hash" binding:"required"`
+	FileName      string   `json:"file_name" binding:"required"`
+	FileSize      int64    `json:"file_size"`
+	ChunkSize     int      `json:"chunk_size" binding:"required"`
+	Chunking      string   `json:"chunking,omitempty"`
+	HashAlgorithm string   `json:"hash_algorithm" binding:"required"`
+	ChunkHashes   []string `json:"chunk_hashes"`
+	ChunkSizes    []int    `json:"chunk_sizes,omitempty"`
+
+	Files []manifestFile `json:"files,omitempty"`
+
+	// Whether the file hash was recomputed from the manifest, which is only impossible
+	// for bare SHA-256 file hashes of whole files. Set by validate.
+	verified bool
+}
+
+// validate checks that the manifest is consistent with itself and with its file hash.
+func (m *fileManifest) validate() error {
+	if err := m.validateLayout(); err != nil {
+		return err
+	}
+	verified, err := m.checkFileHash()
+	if err != nil {
+		return err
+	}
+	m.verified = verified
+	return nil
+}
+
+// validateLayout checks that the manifest is consistent with itself.
+func (m *fileManifest) validateLayout() error {
+	if _, ok := supportedHashAlgorithms[m.HashAlgorithm]; !ok {
+		return fmt.Errorf("unsupported hash algorithm %q", m.HashAlgorithm)
+	}
+	if alg, ok := hashAlgorithmOf(m.FileHash); !ok || alg != m.HashAlgorithm {
+		return fmt.Errorf("file_hash is not a %s hash", m.HashAlgorithm)
+	}
+	if m.ChunkSize <= 0 || m.FileSize < 0 {
+		return fmt.Errorf("file_size must not be negative and chunk_size must be positive")
+	}
+	for i, hash := range m.ChunkHashes {
+		if hash == "" {
+			return fmt.Errorf("chunk %d has no hash", i)
+		}
+	}
+	if m.Chunking != "" && m.Chunking != contentDefinedChunking {
+		return fmt.Errorf("unsupported chunking %q", m.Chunking)
+	}
+	if len(m.Files) > 0 {
+		if err := m.validateChunkSizes(); err != nil {
+			return err
+		}
+		return m.validateFiles()
+	}
+	switch m.Chunking {
+	case "":
+		if len(m.ChunkSizes) > 0 {
+			return fmt.Errorf("chunk_sizes is only allowed with %s chunking or for directories", contentDefinedChunking)
+		}
+	case contentDefinedChunking:
+		return m.validateChunkSizes()
+	default:
+		return fmt.Errorf("unsupported chunking %q", m.Chunking)
+	}
+	if expected := (m.FileSize + int64(m.ChunkSize) - 1) / int64(m.ChunkSize); int64(len(m.ChunkHashes)) != expected {
+		return fmt.Errorf("expected %d chunk hashes, got %d", expected, len(m.ChunkHashes))
+	}
+	return nil
+}
+
+// validateChunkSizes checks that variable chunk sizes are in range and add up to the file size.
+func (m *fileManifest) validateChunkSizes() error {
+	if len(m.ChunkSizes) != len(m.ChunkHashes) {
+		return fmt.Errorf("expected %d chunk sizes, got %d", len(m.ChunkHashes), len(m.ChunkSizes))
+	}
+	var total int64
+	for i, size := range m.ChunkSizes {
+		if size <= 0 || size > m.ChunkSize {
+			return fmt.Errorf("chunk %d has invalid size %d", i, size)
+		}
+		total += int64(size)
+	}
+	if total != m.FileSize {
+		return fmt.Errorf("chunk sizes add up to %d, expected file_size %d", total, m.FileSize)
+	}
+	return nil
+}
+
+// validateFiles checks a directory's file list: paths are clean and relative, sorted
+// and unique, and the files' chunk ranges cover every chunk in order, adding up to each
+// file's size.
+func (m *fileManifest) validateFiles() error {
+	next := 0
+	for i, f := range m.Files {
+		if f.Path == "" || f.Path == "." || strings.ContainsAny(f.Path, "\\\x00") || path.IsAbs(f.Path) ||
+			path.Clean(f.Path) != f.Path || f.Path == ".." || strings.HasPrefix(f.Path, "../") {
+			return fmt.Errorf("file %d has invalid path %q", i, f.Path)
+		}
+		if i > 0 && f.Path <= m.Files[i-1].Path {
+			return fmt.Errorf("files must be sorted by path, with no duplicates")
+		}
+		if f.FirstChunk != next || f.Chunks < 0 || f.Chunks > len(m.ChunkSizes)-next {
+			return fmt.Errorf("file %q has an invalid chunk range", f.Path)
+		}
+		var size int64
+		for _, chunkSize := range m.ChunkSizes[next : next+f.Chunks] {
+			size += int64(chunkSize)
+		}
+		if size != f.Size {
+			return fmt.Errorf("chunks of file %q add up to %d, expected size %d", f.Path, size, f.Size)
+		}
+		next += f.Chunks
+	}
+	if next != len(m.ChunkHashes) {
+		return fmt.Errorf("files cover %d chunks, expected %d", next, len(m.ChunkHashes))
+	}
+	return nil
+}
+
+// sameLayout reports whether two manifests describe the same content.
+func (m *fileManifest) sameLayout(other *fileManifest) bool {
+	if m.FileSize != other.FileSize || m.ChunkSize != other.ChunkSize || m.Chunking != other.Chunking || m.HashAlgorithm != other.HashAlgorithm ||
+		len(m.ChunkHashes) != len(other.ChunkHashes) || len(m.ChunkSizes) != len(other.ChunkSizes) || len(m.Files) != len(other.Files) {
+		return false
+	}
+	for i := range m.Files {
+		if m.Files[i] != other.Files[i] {
+			return false
+		}
+	}
+	for i := range m.ChunkHashes {
+		if m.ChunkHashes[i] != other.ChunkHashes[i] {
+			return false
+		}
+	}
+	for i := range m.ChunkSizes {
+		if m.ChunkSizes[i] != other.ChunkSizes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// loadManifest returns the published manifest of a file, or nil if it has none.
+func loadManifest(q queryer, fileHash string) (*fileManifest, error) {
+	m := &fileManifest{FileHash: fileHash}
+	err := q.QueryRow(`
+        SELECT file_name, file_size, chunk_size, COALESCE(chunking, ''), hash_algorithm
+        FROM files WHERE file_hash = $1 AND file_size IS NOT NULL;`, fileHash).Scan(&m.FileName, &m.FileSize, &m.ChunkSize, &m.Chunking, &m.HashAlgorithm)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fileRows, err := q.Query(`
+        SELECT path, size, first_chunk, chunk_count FROM file_entries
+        WHERE file_hash = $1 ORDER BY first_chunk ASC, path COLLATE "C" ASC;`, fileHash)
+	if err != nil {
+		return nil, err
+	}
+	defer fileRows.Close()
+	for fileRows.Next() {
+		var f manifestFile
+		if err := fileRows.Scan(&f.Path, &f.Size, &f.FirstChunk, &f.Chunks); err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, f)
+	}
+	if err := fileRows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := q.Query(`SELECT chunk_hash, chunk_size FROM file_chunks WHERE file_hash = $1 ORDER BY chunk_index ASC;`, fileHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	m.ChunkHashes = make([]string, 0)
+	for rows.Next() {
+		var chunkHash string
+		var chunkSize sql.NullInt64
+		if err := rows.Scan(&chunkHash, &chunkSize); err != nil {
+			return nil, err
+		}
+		m.ChunkHashes = append(m.ChunkHashes, chunkHash)
+		if m.Chunking != "" || len(m.Files) > 0 {
+			m.ChunkSizes = append(m.ChunkSizes, int(chunkSize.Int64))
+		}
+	}
+	return m, rows.Err()
+}
+
+// errManifestConflict is returned by insertManifest when the file hash already has a
+// different manifest.
+var errManifestConflict = errors.New("a different manifest is already published for this file hash")
+
+// insertManifest records a validated manifest within tx. It reports whether the manifest
+// was new; publishing an identical manifest again is not an error.
+func insertManifest(tx *sql.Tx, m *fileManifest) (bool, error) {
+	// Files announced before manifests existed get theirs filled in, and a manifest the
+	// file hash couldn't be checked against gives way to one that matches it. The row
+	// lock taken here also serialises concurrent publications of the same file.
+	var inserted string
+	err := tx.QueryRow(`
+        INSERT INTO files (file_hash, file_name, total_chunks, file_size, chunk_size, chunking, hash_algorithm, manifest_verified)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, $8)
+        ON CONFLICT (file_hash) DO UPDATE SET
+            file_name = EXCLUDED.file_name, total_chunks = EXCLUDED.total_chunks, file_size = EXCLUDED.file_size,
+            chunk_size = EXCLUDED.chunk_size, chunking = EXCLUDED.chunking, hash_algorithm = EXCLUDED.hash_algorithm,
+            manifest_verified = EXCLUDED.manifest_verified
+        WHERE files.file_size IS NULL OR (NOT files.manifest_verified AND EXCLUDED.manifest_verified)
+        RETURNING file_hash;`,
+		m.FileHash, m.FileName, len(m.ChunkHashes), m.FileSize, m.ChunkSize, m.Chunking, m.HashAlgorithm, m.verified).Scan(&inserted)
+	if err == sql.ErrNoRows {
+		// A manifest was already published for this file hash.
+		existing, err := loadManifest(tx, m.FileHash)
+		if err != nil || existing == nil {
+			return false, fmt.Errorf("failed to load manifest of %s: %v", m.FileHash, err)
+		}
+		if !existing.sameLayout(m) {
+			return false, errManifestConflict
+		}
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to publish manifest: %v", err)
+	}
+
+	// Drop the layout of a manifest being replaced.
+	if _, err := tx.Exec(`DELETE FROM file_chunks WHERE file_hash = $1;`, m.FileHash); err != nil {
+		return false, fmt.Errorf("failed to replace chunk hashes: %v", err)
+	}
+	if _, err := tx.Exec(`DELETE FROM file_entries WHERE file_hash = $1;`, m.FileHash); err != nil {
+		return false, fmt.Errorf("failed to replace file list: %v", err)
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("file_chunks", "file_hash", "chunk_index", "chunk_hash", "chunk_size"))
+	if err != nil {
+		return false, err
+	}
+	for i, chunkHash := range m.ChunkHashes {
+		var chunkSize any // NULL for fixed-size chunks
+		if m.ChunkSizes != nil {
+			chunkSize = m.ChunkSizes[i]
+		}
+		if _, err := stmt.Exec(m.FileHash, i, chunkHash, chunkSize); err != nil {
+			stmt.Close()
+			return false, fmt.Errorf("failed to store chunk hashes: %v", err)
+		}
+	}
+	if _, err := stmt.Exec(); err != nil {
+		stmt.Close()
+		return false, fmt.Errorf("failed to store chunk hashes: %v", err)
+	}
+	stmt.Close()
+
+	if len(m.Files) > 0 {
+		stmt, err := tx.Prepare(pq.CopyIn("file_entries", "file_hash", "path", "size", "first_chunk", "chunk_count"))
+		if err != nil {
+			return false, err
+		}
+		for _, f := range m.Files {
+			if _, err := stmt.Exec(m.FileHash, f.Path, f.Size, f.FirstChunk, f.Chunks); err != nil {
+				stmt.Close()
+				return false, fmt.Errorf("failed to store file list: %v", err)
+			}
+		}
+		if _, err := stmt.Exec(); err != nil {
+			stmt.Close()
+			return false, fmt.Errorf("failed to store file list: %v", err)
+		}
+		stmt.Close()
+	}
+
+	// Announcements made before this manifest was published must agree with it.
+	_, err = tx.Exec(`
+        DELETE FROM file_chunk_peers fcp
+        WHERE fcp.file_hash = $1 AND NOT EXISTS (
+            SELECT 1 FROM file_chunks fc
+            WHERE fc.file_hash = fcp.file_hash AND fc.chunk_index = fcp.chunk_index AND fc.chunk_hash = fcp.chunk_hash
+        );`, m.FileHash)
+	if err != nil {
+		return false, fmt.Errorf("failed to drop announcements that disagree with the manifest: %v", err)
+	}
+	return true, nil
+}
+
+// publishManifest records a file's manifest. Publishing an identical manifest again
+// succeeds; a different manifest for a file hash that already has one is a conflict.
+func publishManifest(db *sql.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req fileManifest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := req.validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback() // Rollback on error
+
+		created, err := insertManifest(tx, &req)
+		if err == errManifestConflict {
+			c.JSON(http.StatusConflict, gin.H{"error": "A different manifest is already published for this file hash"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to publish manifest: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to publish manifest"})
+			return
+		}
+		if !created {
+			c.JSON(http.StatusOK, gin.H{"status": "already published"})
+			return
+		}
+
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"status": "published"})
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	"lukechampine.com/blake3"
+)
+
+// hashConstructors creates a hash for each supported hash algorithm.
+var hashConstructors = map[string]func() hash.Hash{
+	"sha256":     sha256.New,
+	"blake3":     func() hash.Hash { return blake3.New(32, nil) },
+	"sha512-256": sha512.New512_256,
+}
+
+// File hashes are the root of a Merkle tree over the file's chunk hashes, built the way
+// peers build it: leaves are H(0x00 || chunk hash), inner nodes H(0x01 || left || right),
+// and the last node of an odd level is carried up unchanged. A directory's hash covers
+// the root of its chunks and its file list.
+
+// directoryIDTag starts the data hashed into a directory's hash.
+const directoryIDTag = "peernet-dir\x00"
+
+// merkleRoot returns the root digest for a list of hex-encoded chunk hashes.
+func merkleRoot(newHash func() hash.Hash, chunkHashes []string) ([]byte, error) {
+	sum := func(parts ...[]byte) []byte {
+		h := newHash()
+		for _, part := range parts {
+			h.Write(part)
+		}
+		return h.Sum(nil)
+	}
+	if len(chunkHashes) == 0 {
+		return sum(), nil
+	}
+	level := make([][]byte, len(chunkHashes))
+	for i, chunkHash := range chunkHashes {
+		raw, err := hex.DecodeString(chunkHash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hash for chunk %d: %v", i, err)
+		}
+		level[i] = sum([]byte{0x00}, raw)
+	}
+	for len(level) > 1 {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, sum([]byte{0x01}, level[i], level[i+1]))
+			}
+		}
+		level = next
+	}
+	return level[0], nil
+}
+
+// checkFileHash recomputes the file hash from the manifest's chunk hashes, and file list
+// for a directory, and reports whether it matches. A bare SHA-256 file hash may be a flat
+// hash of the whole file, which the tracker can't check, so only a prefixed file hash
+// that doesn't match is an error.
+func (m *fileManifest) checkFileHash() (bool, error) {
+	newHash := hashConstructors[m.HashAlgorithm]
+	digest, err := merkleRoot(newHash, m.ChunkHashes)
+	if err != nil {
+		return false, err
+	}
+	if len(m.Files) > 0 {
+		h := newHash()
+		h.Write([]byte(directoryIDTag))
+		h.Write(digest)
+		for _, f := range m.Files {
+			entry := append([]byte(f.Path), 0)
+			entry = binary.AppendUvarint(entry, uint64(f.Size))
+			entry = binary.AppendUvarint(entry, uint64(f.Chunks))
+			h.Write(entry)
+		}
+		digest = h.Sum(nil)
+	}
+
+	claimed, _ := hex.DecodeString(m.FileHash[len(m.FileHash)-legacyHashLength:])
+	if bytes.Equal(digest, claimed) {
+		return true, nil
+	}
+	if len(m.FileHash) == legacyHashLength {
+		return false, nil
+	}
+	if len(m.Files) > 0 {
+		return false, fmt.Errorf("file_hash does not match the directory's chunk hashes and file list")
+	}
+	return false, fmt.Errorf("file_hash is not the Merkle root of the chunk hashes")
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// merkleVectors are roots over the chunk hashes of "chunk 0", "chunk 1", ...; peers
+// test their Merkle trees against the same vectors, so the two implementations can't
+// drift apart.
+var merkleVectors = []struct {
+	alg    string
+	chunks int
+	root   string
+}{
+	{"sha256", 1, "9c1720a2c8e01fcf0dd095bddf6cfd846e0b799cd87180031af929106a80524e"},
+	{"sha256", 2, "9d7c13656b747dcb0a00fe91f914e8ccebd46f593030eb3e167736081d3ad880"},
+	{"sha256", 3, "7676aa547be2859cb87140342a70dc1c608365b1f6d09085cf106d005dd59e75"},
+	{"sha256", 5, "7a949bf4611de7ddd9c2f4588f1751b396908350124dd901c0dd55710def786e"},
+	{"sha512-256", 1, "fb8f9b15393c4cd5a2cd78039a3db1e6e8fc84ed6e1ba94447cd21efa4ab12bc"},
+	{"sha512-256", 2, "4627a0b883f2fdc21f01e04d3b9fd041608f29f286f566ba326ea740c2c4ece0"},
+	{"sha512-256", 3, "ea6d48fb2d1870a3d722978d432594756f1d5c5a8bd7f4f39f326ae5c2c2c7dc"},
+	{"sha512-256", 5, "437d3db6f632201e98ebad2d4cdd0927dceed227f6a51d48421b44d73738cf40"},
+}
+
+func testChunkHashes(alg string, n int) []string {
+	hashes := make([]string, n)
+	for i := range hashes {
+		h := hashConstructors[alg]()
+		h.Write([]byte(fmt.Sprintf("chunk %d", i)))
+		hashes[i] = hex.EncodeToString(h.Sum(nil))
+	}
+	return hashes
+}
+
+func TestMerkleRoot(t *testing.T) {
+	for _, v := range merkleVectors {
+		root, err := merkleRoot(hashConstructors[v.alg], testChunkHashes(v.alg, v.chunks))
+		if err != nil {
+			t.Fatalf("%s, %d chunks: %v", v.alg, v.chunks, err)
+		}
+		if got := hex.EncodeToString(root); got != v.root {
+			t.Errorf("%s, %d chunks: root %s, want %s", v.alg, v.chunks, got, v.root)
+		}
+	}
+}
+
+func TestCheckFileHash(t *testing.T) {
+	for _, v := range merkleVectors {
+		m := &fileManifest{
+			FileHash:      supportedHashAlgorithms[v.alg] + v.root,
+			HashAlgorithm: v.alg,
+			ChunkHashes:   testChunkHashes(v.alg, v.chunks),
+		}
+		if verified, err := m.checkFileHash(); !verified || err != nil {
+			t.Errorf("%s, %d chunks: checkFileHash() = %t, %v; want true, nil", v.alg, v.chunks, verified, err)
+		}
+
+		m.ChunkHashes[0] = m.ChunkHashes[len(m.ChunkHashes)-1]
+		if v.chunks > 1 {
+			if _, err := m.checkFileHash(); err == nil {
+				t.Errorf("%s, %d chunks: accepted a manifest with the wrong chunk hashes", v.alg, v.chunks)
+			}
+		}
+	}
+}
+
+func TestCheckFileHashLegacy(t *testing.T) {
+	// A bare SHA-256 file hash may be the Merkle root, or a flat hash of the file that
+	// the tracker can't check.
+	v := merkleVectors[3]
+	m := &fileManifest{FileHash: v.root, HashAlgorithm: "sha256", ChunkHashes: testChunkHashes("sha256", v.chunks)}
+	if verified, err := m.checkFileHash(); !verified || err != nil {
+		t.Errorf("checkFileHash() = %t, %v; want true, nil", verified, err)
+	}
+	m.FileHash = strings.Repeat("ab", 32)
+	if verified, err := m.checkFileHash(); verified || err != nil {
+		t.Errorf("checkFileHash() = %t, %v; want false, nil", verified, err)
+	}
+}
+
+func TestCheckFileHashDirectory(t *testing.T) {
+	m := &fileManifest{
+		FileHash:      "1220509ea86bf6a9a41b476860c84e9c1df8868a0d2b4d3b8a348f6c5e038dc082f9",
+		HashAlgorithm: "sha256",
+		ChunkHashes:   testChunkHashes("sha256", 3),
+		Files: []manifestFile{
+			{Path: "a.txt", Size: 100, Chunks: 1},
+			{Path: "sub/b.bin", Size: 300, FirstChunk: 1, Chunks: 2},
+		},
+	}
+	if verified, err := m.checkFileHash(); !verified || err != nil {
+		t.Errorf("checkFileHash() = %t, %v; want true, nil", verified, err)
+	}
+	m.Files[1].Size++
+	if _, err := m.checkFileHash(); err == nil {
+		t.Error("accepted a directory whose file list doesn't match its hash")
+	}
+}
@@ -0,0 +1,237 @@
+package api
+
+import (
+	"database/sql"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ShreyamKundu/peernet/tracker/auth"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type loginRequest struct {
+	PeerID   string `json:"peer_id" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+type passwordChangeRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// issueTokens issues an access token and a refresh token for a peer. Refresh tokens
+// rotate: each one can be exchanged once, for a new pair in the same family, and every
+// login starts a new family. Only the refresh token's hash is stored.
+func issueTokens(db execer, peerID, familyID, jwtSecret string, refreshTTL time.Duration) (string, string, error) {
+	accessToken, err := auth.GenerateToken(peerID, jwtSecret)
+	if err != nil {
+		return "", "", err
+	}
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return "", "", err
+	}
+	if familyID == "" {
+		familyID = uuid.New().String()
+	}
+	// Expired tokens are no longer needed to detect reuse.
+	if _, err := db.Exec(`DELETE FROM refresh_tokens WHERE peer_id = $1 AND expires_at < NOW();`, peerID); err != nil {
+		return "", "", err
+	}
+	_, err = db.Exec(`
+        INSERT INTO refresh_tokens (token_hash, peer_id, family_id, expires_at)
+        VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second');`,
+		auth.HashRefreshToken(refreshToken), peerID, familyID, refreshTTL.Seconds())
+	if err != nil {
+		return "", "", err
+	}
+	return accessToken, refreshToken, nil
+}
+
+// dummyPasswordHash is checked against the password given for an unknown peer, so that
+// logging in takes as long whether or not the peer ID is registered.
+const dummyPasswordHash = "$2a$10$Y4wigb8Q4kxjW.fhgoit1OPgX5OGFXxxZ9ntV2Vy4EgpUwdU5ZBh6"
+
+// login issues new tokens to an existing peer that proves its password, so a peer whose
+// tokens expired keeps its identity, reputation and token balance.
+func login(db *sql.DB, jwtSecret string, refreshTTL time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req loginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Unknown peers and wrong passwords get the same answer, after the same work.
+		var passwordHash string
+		err := sql.ErrNoRows
+		if _, parseErr := uuid.Parse(req.PeerID); parseErr == nil {
+			err = db.QueryRow(`SELECT password_hash FROM peers WHERE id = $1;`, req.PeerID).Scan(&passwordHash)
+		}
+		if err == sql.ErrNoRows {
+			bcrypt.CompareHashAndPassword([]byte(dummyPasswordHash), []byte(req.Password))
+		} else if err == nil {
+			err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password))
+		}
+		if err == sql.ErrNoRows || err == bcrypt.ErrMismatchedHashAndPassword {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid peer ID or password"})
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to log in peer %s: %v", req.PeerID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		token, refreshToken, err := issueTokens(db, req.PeerID, "", jwtSecret, refreshTTL)
+		if err != nil {
+			log.Printf("Failed to issue tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"peer_id": req.PeerID, "token": token, "refresh_token": refreshToken})
+	}
+}
+
+// refreshTokens exchanges a refresh token for a new access token and refresh token. A
+// refresh token that is presented twice has been copied, so its whole family is revoked
+// and the peer has to log in again.
+func refreshTokens(db *sql.DB, jwtSecret string, refreshTTL time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req refreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback() // Rollback on error
+
+		var peerID, familyID string
+		var expired, used bool
+		err = tx.QueryRow(`
+            SELECT peer_id, family_id, expires_at < NOW(), used_at IS NOT NULL
+            FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE;`,
+			auth.HashRefreshToken(req.RefreshToken)).Scan(&peerID, &familyID, &expired, &used)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+
+		if used {
+			log.Printf("Refresh token of peer %s was reused; revoking its session", peerID)
+			if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE family_id = $1;`, familyID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+				return
+			}
+			if err := tx.Commit(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used; the session has been revoked. Please log in again."})
+			return
+		}
+		if expired {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token expired. Please log in again."})
+			return
+		}
+
+		if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = NOW() WHERE token_hash = $1;`, auth.HashRefreshToken(req.RefreshToken)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		token, refreshToken, err := issueTokens(tx, peerID, familyID, jwtSecret, refreshTTL)
+		if err != nil {
+			log.Printf("Failed to issue tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"peer_id": peerID, "token": token, "refresh_token": refreshToken})
+	}
+}
+
+// changePassword replaces the peer's password after checking the current one. Every
+// refresh token of the peer is revoked and the caller gets a new pair; access tokens
+// already issued stay valid until they expire.
+func changePassword(db *sql.DB, jwtSecret string, refreshTTL time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req passwordChangeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		peerID, _ := c.Get("peerID")
+
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback() // Rollback on error
+
+		var passwordHash string
+		err = tx.QueryRow(`SELECT password_hash FROM peers WHERE id = $1 FOR UPDATE;`, peerID.(string)).Scan(&passwordHash)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Peer is not registered"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.CurrentPassword)) != nil {
+			// Not 401, which clients take to mean their access token needs refreshing.
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+			return
+		}
+
+		newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to hash password: " + err.Error()})
+			return
+		}
+		if _, err := tx.Exec(`UPDATE peers SET password_hash = $2 WHERE id = $1;`, peerID.(string), string(newHash)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+		if _, err := tx.Exec(`DELETE FROM refresh_tokens WHERE peer_id = $1;`, peerID.(string)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+			return
+		}
+		token, refreshToken, err := issueTokens(tx, peerID.(string), "", jwtSecret, refreshTTL)
+		if err != nil {
+			log.Printf("Failed to issue tokens: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate token"})
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction commit failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"peer_id": peerID, "token": token, "refresh_token": refreshToken})
+	}
+}
@@ -1,28 +1,88 @@
 package config
 
-import "os"
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
 
 // Config holds all configuration for the application.
 type Config struct {
-	Port string
-	DatabaseURL string
-	JWTSecret string
-}
+	Port            string
+	DatabaseURL     string
+	JWTSecret       string
+	RefreshTokenTTL time.Duration // How long a refresh token can be exchanged for new tokens
+
+	// HTTPS settings. With neither a certificate nor self-signed mode the tracker serves plain HTTP.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string // If set, peers must present a client certificate signed by this CA
+	TLSSelfSigned   bool   // Dev mode: generate a self-signed certificate at startup
 
+	// Peer liveness. A peer that sends no heartbeat for PeerOfflineAfter is marked offline
+	// and left out of lookups; after PeerPurgeAfter its chunk announcements are deleted.
+	PeerOfflineAfter time.Duration
+	PeerPurgeAfter   time.Duration
+
+	// Peer reachability. Every online peer is dialed back at its registered address once
+	// per PeerProbeInterval. Peers that require mutual TLS are presented this certificate.
+	// Addresses on loopback, link-local and private networks are only dialed if
+	// ProbeAllowPrivate is set, for trackers serving a local network.
+	PeerProbeInterval time.Duration
+	ProbeCertFile     string
+	ProbeKeyFile      string
+	ProbeAllowPrivate bool
+}
 
 func New() *Config {
-	return &Config {
-		Port: getEnv("TRACKER_PORT", "8080"),
-		DatabaseURL: getEnv("DATABASE_URL", "postgres://user:password@db:5432/peernet?sslmode=disable"),
-		JWTSecret: getEnv("JWT_SECRET", "a_very_secret_key_that_should_be_changed"),
+	return &Config{
+		Port:              getEnv("TRACKER_PORT", "8080"),
+		DatabaseURL:       getEnv("DATABASE_URL", "postgres://user:password@db:5432/peernet?sslmode=disable"),
+		JWTSecret:         getEnv("JWT_SECRET", "a_very_secret_key_that_should_be_changed"),
+		RefreshTokenTTL:   getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour),
+		TLSCertFile:       getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:        getEnv("TLS_KEY_FILE", ""),
+		TLSClientCAFile:   getEnv("TLS_CLIENT_CA_FILE", ""),
+		TLSSelfSigned:     getEnvBool("TLS_SELF_SIGNED", false),
+		PeerOfflineAfter:  getEnvDuration("PEER_OFFLINE_AFTER", 2*time.Minute),
+		PeerPurgeAfter:    getEnvDuration("PEER_PURGE_AFTER", 24*time.Hour),
+		PeerProbeInterval: getEnvDuration("PEER_PROBE_INTERVAL", 10*time.Minute),
+		ProbeCertFile:     getEnv("PROBE_TLS_CERT_FILE", ""),
+		ProbeKeyFile:      getEnv("PROBE_TLS_KEY_FILE", ""),
+		ProbeAllowPrivate: getEnvBool("PROBE_ALLOW_PRIVATE", false),
 	}
 }
 
-
 func getEnv(key, defaultValue string) string {
 	if value, ok := os.LookupEnv(key); ok {
 		return value
-	} 
+	}
 
 	return defaultValue
 }
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return parsed
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return defaultValue
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		log.Printf("Invalid duration %q for %s, using %s", value, key, defaultValue)
+		return defaultValue
+	}
+	return parsed
+}
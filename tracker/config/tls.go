@@ -0,0 +1,135 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+// TLSEnabled reports whether the tracker should serve HTTPS.
+func (c *Config) TLSEnabled() bool {
+	return c.TLSSelfSigned || (c.TLSCertFile != "" && c.TLSKeyFile != "")
+}
+
+// TLSConfig builds the server's TLS configuration. It returns nil if TLS is disabled.
+//
+// In self-signed mode a certificate is generated at startup. If TLSCertFile and
+// TLSKeyFile are set but don't exist yet, the generated pair is written there so it
+// survives restarts and can be handed to peers to pin.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	if !c.TLSEnabled() {
+		return nil, nil
+	}
+
+	var cert tls.Certificate
+	var err error
+	if c.TLSSelfSigned && !fileExists(c.TLSCertFile) {
+		cert, err = generateSelfSigned(c.TLSCertFile, c.TLSKeyFile)
+	} else {
+		cert, err = tls.LoadX509KeyPair(c.TLSCertFile, c.TLSKeyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+	log.Printf("TLS certificate SHA-256 fingerprint: %s", hex.EncodeToString(fingerprint[:]))
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if c.TLSClientCAFile != "" {
+		data, err := os.ReadFile(c.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in client CA file %s", c.TLSClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// ProbeCertificate loads the client certificate presented when dialing back peers that
+// require mutual TLS. It returns nil if none is configured.
+func (c *Config) ProbeCertificate() (*tls.Certificate, error) {
+	if c.ProbeCertFile == "" || c.ProbeKeyFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.ProbeCertFile, c.ProbeKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load probe certificate: %v", err)
+	}
+	return &cert, nil
+}
+
+// generateSelfSigned creates a certificate for localhost and this machine's host name,
+// writing it to certFile and keyFile when those are set.
+func generateSelfSigned(certFile, keyFile string) (tls.Certificate, error) {
+	log.Println("Generating self-signed TLS certificate (dev mode). Do not use this in production.")
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "peernet-tracker", Organization: []string{"PeerNet"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true, // So peers can use the certificate itself as their CA file
+		DNSNames:              []string{"localhost", "tracker"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	if certFile != "" && keyFile != "" {
+		if err := os.WriteFile(certFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+		log.Printf("Self-signed certificate written to %s", certFile)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+func fileExists(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
@@ -45,6 +45,19 @@ func createSchema(db *sql.DB) error {
         created_at TIMESTAMPTZ DEFAULT NOW()
     );
 
+    -- Manifest fields. They stay NULL for files announced before manifests existed.
+    ALTER TABLE files ADD COLUMN IF NOT EXISTS file_size BIGINT;
+    ALTER TABLE files ADD COLUMN IF NOT EXISTS chunk_size INT;
+    ALTER TABLE files ADD COLUMN IF NOT EXISTS hash_algorithm TEXT;
+
+    -- The ordered chunk hashes of each published manifest.
+    CREATE TABLE IF NOT EXISTS file_chunks (
+        file_hash TEXT NOT NULL REFERENCES files(file_hash) ON DELETE CASCADE,
+        chunk_index INT NOT NULL,
+        chunk_hash TEXT NOT NULL,
+        PRIMARY KEY (file_hash, chunk_index)
+    );
+
     CREATE TABLE IF NOT EXISTS file_chunk_peers (
         file_hash TEXT NOT NULL REFERENCES files(file_hash) ON DELETE CASCADE,
         chunk_index INT NOT NULL,
@@ -53,6 +66,48 @@ func createSchema(db *sql.DB) error {
         PRIMARY KEY (file_hash, chunk_index, peer_id)
     );
 
+    -- Content-defined chunking. Files with variable-size chunks record each chunk's size.
+    ALTER TABLE files ADD COLUMN IF NOT EXISTS chunking TEXT;
+    ALTER TABLE file_chunks ADD COLUMN IF NOT EXISTS chunk_size INT;
+
+    -- Lookups find peers holding an identical chunk as part of any file.
+    CREATE INDEX IF NOT EXISTS file_chunk_peers_chunk_hash_idx ON file_chunk_peers (chunk_hash);
+
+    -- The files of a shared directory and the range of its chunks each one occupies.
+    CREATE TABLE IF NOT EXISTS file_entries (
+        file_hash TEXT NOT NULL REFERENCES files(file_hash) ON DELETE CASCADE,
+        path TEXT NOT NULL,
+        size BIGINT NOT NULL,
+        first_chunk INT NOT NULL,
+        chunk_count INT NOT NULL,
+        PRIMARY KEY (file_hash, path)
+    );
+
+    -- Whether the file hash was checked against the manifest's chunk hashes. A manifest
+    -- that couldn't be is replaced by one that matches if it is ever published.
+    ALTER TABLE files ADD COLUMN IF NOT EXISTS manifest_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+    -- Peers that stop sending heartbeats are marked offline and left out of lookups.
+    ALTER TABLE peers ADD COLUMN IF NOT EXISTS online BOOLEAN NOT NULL DEFAULT TRUE;
+
+    -- Whether the tracker could dial the peer back at its address; NULL until first checked.
+    ALTER TABLE peers ADD COLUMN IF NOT EXISTS reachable BOOLEAN;
+    ALTER TABLE peers ADD COLUMN IF NOT EXISTS reachability_error TEXT;
+    ALTER TABLE peers ADD COLUMN IF NOT EXISTS reachability_checked_at TIMESTAMPTZ;
+
+    -- Refresh tokens, stored by hash. Each is exchanged once for a new one in the same
+    -- family; a used token presented again revokes its family.
+    CREATE TABLE IF NOT EXISTS refresh_tokens (
+        token_hash TEXT PRIMARY KEY,
+        peer_id UUID NOT NULL REFERENCES peers(id) ON DELETE CASCADE,
+        family_id UUID NOT NULL,
+        expires_at TIMESTAMPTZ NOT NULL,
+        used_at TIMESTAMPTZ,
+        created_at TIMESTAMPTZ DEFAULT NOW()
+    );
+    CREATE INDEX IF NOT EXISTS refresh_tokens_family_id_idx ON refresh_tokens (family_id);
+    CREATE INDEX IF NOT EXISTS refresh_tokens_peer_id_idx ON refresh_tokens (peer_id);
+
     CREATE TABLE IF NOT EXISTS reputation_events (
         id SERIAL PRIMARY KEY,
         reporter_peer_id UUID NOT NULL REFERENCES peers(id) ON DELETE CASCADE,
@@ -3,12 +3,15 @@ module github.com/ShreyamKundu/peernet/tracker
 go 1.24.1
 
 require (
+	github.com/ShreyamKundu/peernet v0.0.0-20250708180057-fb06273adf84
 	github.com/gin-gonic/gin v1.10.1
 	github.com/golang-jwt/jwt/v5 v5.2.2
 	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.39.0
+	google.golang.org/grpc v1.73.0
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
@@ -32,9 +35,13 @@ require (
 	github.com/twitchyliquid64/golang-asm v0.15.1 // indirect
 	github.com/ugorji/go/codec v1.2.12 // indirect
 	golang.org/x/arch v0.8.0 // indirect
-	golang.org/x/net v0.25.0 // indirect
+	golang.org/x/net v0.38.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.26.0 // indirect
-	google.golang.org/protobuf v1.34.1 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250324211829-b45e905df463 // indirect
+	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+// The generated gRPC code lives in the repository root module; build against the local copy.
+replace github.com/ShreyamKundu/peernet => ../
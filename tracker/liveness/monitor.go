@@ -0,0 +1,82 @@
+package liveness
+
+import (
+	"database/sql"
+	"log"
+	"time"
+)
+
+// minCheckPeriod bounds how often the monitor checks, however short offlineAfter is.
+const minCheckPeriod = time.Second
+
+// Monitor marks peers offline when they stop sending heartbeats, and eventually deletes
+// the chunk announcements of peers that stay offline.
+type Monitor struct {
+	db           *sql.DB
+	offlineAfter time.Duration
+	purgeAfter   time.Duration
+	ticker       *time.Ticker
+	done         chan bool
+}
+
+// NewMonitor creates a monitor that marks peers offline after offlineAfter without a
+// heartbeat and purges their announcements once they have been silent for purgeAfter.
+func NewMonitor(db *sql.DB, offlineAfter, purgeAfter time.Duration) *Monitor {
+	return &Monitor{
+		db:           db,
+		offlineAfter: offlineAfter,
+		purgeAfter:   purgeAfter,
+		done:         make(chan bool),
+	}
+}
+
+// Start begins checking peers periodically, often enough that a silent peer is marked
+// offline soon after offlineAfter has passed.
+func (m *Monitor) Start() {
+	log.Printf("Starting liveness monitor (offline after %s, purged after %s)...", m.offlineAfter, m.purgeAfter)
+	m.ticker = time.NewTicker(max(m.offlineAfter/4, minCheckPeriod))
+	for {
+		select {
+		case <-m.done:
+			m.ticker.Stop()
+			log.Println("Liveness monitor stopped.")
+			return
+		case <-m.ticker.C:
+			if err := m.check(); err != nil {
+				log.Printf("Error checking peer liveness: %v", err)
+			}
+		}
+	}
+}
+
+// Stop halts the monitor.
+func (m *Monitor) Stop() {
+	m.done <- true
+}
+
+// check marks silent peers offline and purges the announcements of long-silent ones.
+func (m *Monitor) check() error {
+	result, err := m.db.Exec(`
+		UPDATE peers SET online = FALSE
+		WHERE online AND last_seen < NOW() - $1 * INTERVAL '1 second'
+	`, m.offlineAfter.Seconds())
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Marked %d silent peers offline", n)
+	}
+
+	result, err = m.db.Exec(`
+		DELETE FROM file_chunk_peers fcp
+		USING peers p
+		WHERE fcp.peer_id = p.id AND NOT p.online AND p.last_seen < NOW() - $1 * INTERVAL '1 second'
+	`, m.purgeAfter.Seconds())
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Purged %d chunk announcements of offline peers", n)
+	}
+	return nil
+}
@@ -11,12 +11,14 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
 	"github.com/ShreyamKundu/peernet/tracker/api"
 	"github.com/ShreyamKundu/peernet/tracker/config"
 	"github.com/ShreyamKundu/peernet/tracker/db"
+	"github.com/ShreyamKundu/peernet/tracker/liveness"
+	"github.com/ShreyamKundu/peernet/tracker/reachability"
 	"github.com/ShreyamKundu/peernet/tracker/reputation"
+	"github.com/gin-gonic/gin"
+	"github.com/joho/godotenv"
 )
 
 func main() {
@@ -36,18 +38,45 @@ func main() {
 	reputationEngine := reputation.NewEngine(database)
 	go reputationEngine.Start()
 
+	// Start the liveness monitor
+	livenessMonitor := liveness.NewMonitor(database, cfg.PeerOfflineAfter, cfg.PeerPurgeAfter)
+	go livenessMonitor.Start()
+
+	// Start the reachability prober
+	probeCert, err := cfg.ProbeCertificate()
+	if err != nil {
+		log.Fatalf("Failed to configure reachability checks: %v", err)
+	}
+	prober := reachability.NewProber(database, cfg.PeerProbeInterval, probeCert, cfg.ProbeAllowPrivate)
+	go prober.Start()
+
 	// Set up Gin router
-	router := setupRouter(database, cfg.JWTSecret)
-	
+	router := setupRouter(database, cfg, prober)
+
+	tlsConfig, err := cfg.TLSConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+
 	// Set up the HTTP server
 	srv := &http.Server{
-		Addr:    fmt.Sprintf(":%s", cfg.Port),
-		Handler: router,
+		Addr:      fmt.Sprintf(":%s", cfg.Port),
+		Handler:   router,
+		TLSConfig: tlsConfig,
 	}
 
 	// Graceful shutdown
 	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if tlsConfig != nil {
+			log.Printf("Serving HTTPS on port %s", cfg.Port)
+			// The certificate is already in TLSConfig, so no files are passed here.
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			log.Printf("Serving plain HTTP on port %s", cfg.Port)
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("listen: %s\n", err)
 		}
 	}()
@@ -61,6 +90,8 @@ func main() {
 	defer cancel()
 
 	reputationEngine.Stop() // Stop the reputation engine
+	livenessMonitor.Stop()
+	prober.Stop()
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
@@ -68,7 +99,7 @@ func main() {
 	log.Println("Server exiting")
 }
 
-func setupRouter(database *sql.DB, jwtSecret string) *gin.Engine {
+func setupRouter(database *sql.DB, cfg *config.Config, prober *reachability.Prober) *gin.Engine {
 	router := gin.Default()
 	router.Use(gin.Recovery())
 
@@ -78,7 +109,7 @@ func setupRouter(database *sql.DB, jwtSecret string) *gin.Engine {
 	})
 
 	apiV1 := router.Group("/api/v1")
-	api.RegisterRoutes(apiV1, database, jwtSecret)
+	api.RegisterRoutes(apiV1, database, cfg, prober)
 
 	return router
-}
\ No newline at end of file
+}
@@ -0,0 +1,248 @@
+package reachability
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	pb "github.com/ShreyamKundu/peernet/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	pingTimeout = 5 * time.Second  // How long a peer has to answer a ping
+	batchSize   = 50               // Peers checked per round
+	roundPeriod = 30 * time.Second // How often peers due for a check are looked for
+	workers     = 16               // Checks running at once
+	queueSize   = 4 * batchSize    // Checks waiting for a worker; more are dropped until the next round
+)
+
+// Prober dials peers back at their registered addresses over gRPC and records whether
+// they answered, so addresses the tracker can't reach aren't handed out as if they worked.
+// Checks run on a fixed number of workers, and a peer or address is only queued once at a
+// time, so registrations can't make the tracker dial out without bound.
+type Prober struct {
+	db           *sql.DB
+	interval     time.Duration
+	tlsConfig    *tls.Config
+	allowPrivate bool
+	ticker       *time.Ticker
+	done         chan bool
+
+	queue   chan probe
+	stop    chan struct{} // Closed when the prober stops
+	mu      sync.Mutex    // Guards pending
+	pending map[string]bool
+}
+
+// probe is a queued check of one peer.
+type probe struct{ peerID, address string }
+
+// NewProber creates a prober that checks every online peer again once its last check
+// is older than interval. Peers serving TLS are pinged without verifying their
+// certificate, since only reachability is checked; clientCert, if not nil, is presented
+// to peers that require mutual TLS. Unless allowPrivate is set, addresses on loopback,
+// link-local and private networks are never dialed and count as unreachable.
+func NewProber(db *sql.DB, interval time.Duration, clientCert *tls.Certificate, allowPrivate bool) *Prober {
+	tlsConfig := &tls.Config{InsecureSkipVerify: true, MinVersion: tls.VersionTLS12}
+	if clientCert != nil {
+		tlsConfig.Certificates = []tls.Certificate{*clientCert}
+	}
+	return &Prober{
+		db:           db,
+		interval:     interval,
+		tlsConfig:    tlsConfig,
+		allowPrivate: allowPrivate,
+		done:         make(chan bool),
+		queue:        make(chan probe, queueSize),
+		stop:         make(chan struct{}),
+		pending:      make(map[string]bool),
+	}
+}
+
+// Start begins checking peers periodically.
+func (p *Prober) Start() {
+	log.Printf("Starting reachability prober (every %s)...", p.interval)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	p.ticker = time.NewTicker(roundPeriod)
+	for {
+		select {
+		case <-p.done:
+			p.ticker.Stop()
+			close(p.stop)
+			log.Println("Reachability prober stopped.")
+			return
+		case <-p.ticker.C:
+			if err := p.checkDue(); err != nil {
+				log.Printf("Error checking peer reachability: %v", err)
+			}
+		}
+	}
+}
+
+// Stop halts the prober.
+func (p *Prober) Stop() {
+	p.done <- true
+}
+
+// checkDue queues checks of the online peers that were never checked or whose last
+// check is older than the interval, oldest first.
+func (p *Prober) checkDue() error {
+	rows, err := p.db.Query(`
+		SELECT id, address FROM peers
+		WHERE online AND (reachability_checked_at IS NULL OR reachability_checked_at < NOW() - $1 * INTERVAL '1 second')
+		ORDER BY reachability_checked_at ASC NULLS FIRST
+		LIMIT $2
+	`, p.interval.Seconds(), batchSize)
+	if err != nil {
+		return err
+	}
+	type peer struct{ id, address string }
+	var due []peer
+	for rows.Next() {
+		var pr peer
+		if err := rows.Scan(&pr.id, &pr.address); err != nil {
+			log.Printf("Error scanning peer row: %v", err)
+			continue
+		}
+		due = append(due, pr)
+	}
+	rows.Close()
+
+	for _, pr := range due {
+		p.Check(pr.id, pr.address)
+	}
+	return nil
+}
+
+// Check queues a check of a peer at its registered address without waiting for it. It
+// does nothing if the peer or address is already queued, or if the queue is full; the
+// peer is then checked in a later round.
+func (p *Prober) Check(peerID, address string) {
+	p.mu.Lock()
+	if p.pending[peerID] || p.pending[address] {
+		p.mu.Unlock()
+		return
+	}
+	p.pending[peerID], p.pending[address] = true, true
+	p.mu.Unlock()
+
+	select {
+	case p.queue <- probe{peerID, address}:
+	default:
+		p.finish(probe{peerID, address})
+	}
+}
+
+func (p *Prober) finish(pr probe) {
+	p.mu.Lock()
+	delete(p.pending, pr.peerID)
+	delete(p.pending, pr.address)
+	p.mu.Unlock()
+}
+
+// work runs queued checks until the prober stops.
+func (p *Prober) work() {
+	for {
+		select {
+		case <-p.stop:
+			return
+		case pr := <-p.queue:
+			p.check(pr.peerID, pr.address)
+			p.finish(pr)
+		}
+	}
+}
+
+// check pings a peer at its registered address and records the result.
+func (p *Prober) check(peerID, address string) {
+	err := p.ping(peerID, address)
+	var reason sql.NullString
+	if err != nil {
+		log.Printf("Peer %s is unreachable at %s: %v", peerID, address, err)
+		reason = sql.NullString{String: err.Error(), Valid: true}
+	}
+	_, dbErr := p.db.Exec(`
+		UPDATE peers SET reachable = $2, reachability_error = $3, reachability_checked_at = NOW()
+		WHERE id = $1
+	`, peerID, err == nil, reason)
+	if dbErr != nil {
+		log.Printf("Failed to record reachability of peer %s: %v", peerID, dbErr)
+	}
+}
+
+// ping calls the peer's Ping RPC, in plaintext and, failing that, over TLS. A peer that
+// answers with another peer's ID is not the one registered at the address. Peers too old
+// to implement Ping still prove they are listening by rejecting it.
+func (p *Prober) ping(peerID, address string) error {
+	err := p.pingWith(peerID, address, insecure.NewCredentials())
+	if status.Code(err) != codes.Unavailable {
+		return err
+	}
+	if tlsErr := p.pingWith(peerID, address, credentials.NewTLS(p.tlsConfig)); status.Code(tlsErr) != codes.Unavailable {
+		return tlsErr
+	}
+	return err
+}
+
+func (p *Prober) pingWith(peerID, address string, creds credentials.TransportCredentials) error {
+	dialer := &net.Dialer{Control: p.checkTarget}
+	conn, err := grpc.NewClient(address, grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "tcp", addr)
+		}))
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancel()
+	resp, err := pb.NewPeerServiceClient(conn).Ping(ctx, &pb.PingRequest{})
+	// Other HTTP/2 servers answer with a 404, which also maps to Unimplemented.
+	if status.Code(err) == codes.Unimplemented && strings.HasPrefix(status.Convert(err).Message(), "unknown method") {
+		return nil
+	}
+	if status.Code(err) == codes.DeadlineExceeded {
+		return status.Error(codes.Unavailable, "no answer within "+pingTimeout.String())
+	}
+	if err != nil {
+		return err
+	}
+	if resp.PeerId != "" && resp.PeerId != peerID {
+		return status.Error(codes.FailedPrecondition, fmt.Sprintf("address is served by peer %s", resp.PeerId))
+	}
+	return nil
+}
+
+// checkTarget refuses connections to loopback, link-local, private and unspecified
+// addresses unless they are allowed. It runs on the resolved address just before
+// connecting, so a host name can't be used to get around it.
+func (p *Prober) checkTarget(network, address string, _ syscall.RawConn) error {
+	if p.allowPrivate {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("address %s is on a loopback, link-local or private network", host)
+	}
+	return nil
+}